@@ -4,25 +4,25 @@ go 1.24.6
 
 require (
 	github.com/HubertasVin/chanseq v0.1.0
+	github.com/bodgit/sevenzip v1.6.1
 	github.com/fatih/color v1.18.0
 	github.com/icza/gox v0.2.0
+	github.com/klauspost/compress v1.17.11
+	github.com/nwaples/rardecode v1.1.3
 	github.com/pelletier/go-toml/v2 v2.2.4
+	github.com/pierrec/lz4/v4 v4.1.22
 	github.com/spf13/pflag v1.0.7
+	github.com/ulikunitz/xz v0.5.15
 )
 
 require (
 	github.com/andybalholm/brotli v1.1.1 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
-	github.com/bodgit/sevenzip v1.6.1 // indirect
 	github.com/bodgit/windows v1.0.1 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/nwaples/rardecode v1.1.3 // indirect
-	github.com/pierrec/lz4/v4 v4.1.22 // indirect
 	github.com/spf13/afero v1.11.0 // indirect
-	github.com/ulikunitz/xz v0.5.15 // indirect
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/HubertasVin/findstr/mappers"
 	"github.com/HubertasVin/findstr/models"
@@ -20,7 +22,7 @@ func main() {
 	defer stop()
 	signal.Ignore(syscall.SIGPIPE)
 
-	showVersion, exdir, exfile, threadc, contextSize, root, pattern, skipGit, searchArch, jsonOut, createConfig, err := parseFlags()
+	flags, showVersion, createConfig, err := parseFlags()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		fmt.Fprintln(os.Stderr)
@@ -43,34 +45,36 @@ func main() {
 		return
 	}
 
-	flags := models.ProgramFlags{
-		ExcludeDir:  *exdir,
-		ExcludeFile: *exfile,
-		ThreadCount: threadc,
-		ContextSize: contextSize,
-		Root:        *root,
-		SkipGit:     skipGit,
-		SearchArch:  searchArch,
-		Json:        jsonOut,
-		Pattern:     pattern,
-	}
-
-	if threadc <= 0 {
+	if flags.ThreadCount <= 0 {
 		fmt.Println("Error: Thread count must be greater than 0")
 		os.Exit(1)
 	}
-	if contextSize < 0 {
+	if flags.BeforeContext < 0 || flags.AfterContext < 0 {
 		fmt.Println("Error: Context size must be greater than or equal to 0")
 		os.Exit(1)
 	}
+	if flags.ArchiveDepth <= 0 {
+		fmt.Println("Error: Archive depth must be greater than 0")
+		os.Exit(1)
+	}
+	if flags.MaxColumns < 0 {
+		fmt.Println("Error: Max columns must be greater than or equal to 0")
+		os.Exit(1)
+	}
+	if flags.MaxCount < 0 || flags.MaxResults < 0 {
+		fmt.Println("Error: Max count and max results must be greater than or equal to 0")
+		os.Exit(1)
+	}
 
-	cl, theme, err := utils.LoadConfig()
+	cl, theme, passwords, err := utils.LoadConfig()
 	if err != nil {
 		fmt.Println("Error: While loading config: " + err.Error())
 		os.Exit(1)
 	}
+	flags.ArchivePasswords = passwords
 
-	matches, err := utils.SearchMatchLines(ctx, flags)
+	start := time.Now()
+	matches, errs, stats, err := utils.SearchMatchLines(ctx, flags)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			os.Exit(130)
@@ -79,26 +83,58 @@ func main() {
 		os.Exit(1)
 	}
 
-	if jsonOut {
-		matchesArr := mappers.MapChanToJsonFile(ctx, matches)
-		out, err := utils.BuildJson(matchesArr)
+	if flags.JsonLines {
+		if err := utils.WriteJsonLines(ctx, matches, errs, os.Stdout, start, flags.ArchiveSep); err != nil {
+			if errors.Is(err, context.Canceled) {
+				os.Exit(130)
+			}
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		printStats(flags, stats)
+		return
+	}
+
+	// With --json the errors are part of the output instead.
+	report := utils.CollectErrors(errs, flags.QuietErrors || flags.Json, flags.ArchiveSep)
+
+	if flags.Json {
+		matchesArr := mappers.MapChanToJsonFile(ctx, matches, flags.ArchiveSep)
+		errsArr := mappers.MapErrorsToJsonFile(report.Errors(), flags.ArchiveSep)
+		out, err := utils.BuildJson(matchesArr, errsArr)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 		fmt.Println(out)
+		printErrorSummary(report)
+		printStats(flags, stats)
 		return
 	}
 
-	utils.PrintMatches(ctx, matches, cl, theme, contextSize)
+	utils.PrintMatches(ctx, matches, cl, theme, flags)
 
 	if ctx.Err() != nil {
 		fmt.Fprint(os.Stdout, "\x1b[0m\x1b[K\n")
 		os.Exit(130)
 	}
+	printErrorSummary(report)
+	printStats(flags, stats)
+}
+
+func printErrorSummary(report *utils.ErrorReport) {
+	if summary := report.Summary(); summary != "" {
+		fmt.Fprintln(os.Stderr, summary)
+	}
+}
+
+func printStats(flags models.ProgramFlags, stats *utils.SearchStats) {
+	if flags.Stats {
+		fmt.Fprintln(os.Stderr, stats.Format(time.Now()))
+	}
 }
 
-func parseFlags() (bool, *string, *string, int, int, *string, string, bool, bool, bool, bool, error) {
+func parseFlags() (models.ProgramFlags, bool, bool, error) {
 	showVersion := pflag.BoolP("version", "v", false, "print version information")
 	exdir := pflag.StringP("exclude-dir", "e", "", "relative paths to ignore")
 	exfile := pflag.StringP(
@@ -109,14 +145,42 @@ func parseFlags() (bool, *string, *string, int, int, *string, string, bool, bool
 	)
 	threadc := pflag.IntP("thread", "t", 1, "thread count to use for file parsing")
 	context := pflag.IntP("context", "c", 2, "number of context lines to show around a matched line")
+	beforeContext := pflag.IntP("before-context", "B", 0, "number of context lines to show before a matched line (overrides --context)")
+	afterContext := pflag.IntP("after-context", "A", 0, "number of context lines to show after a matched line (overrides --context)")
+	maxColumns := pflag.Int("max-columns", 0, "truncate printed lines longer than this many bytes around the match (0 = no limit)")
 	root := pflag.StringP("root", "r", "./", "root directory to walk")
 	skipGit := pflag.BoolP("git", "g", false, "skip .git directory")
-	searchArch := pflag.BoolP("search-archives", "a", false, "search inside zip and tar archives")
+	noIgnore := pflag.Bool("no-ignore", false, "don't respect .gitignore, .ignore and .findstrignore files")
+	searchArch := pflag.BoolP("search-archives", "a", false, "search inside archives (zip, jar, tar, rar, 7z, cpio, ar/deb, iso)")
+	searchZip := pflag.BoolP("search-zip", "z", false, "search inside gzip, bzip2, xz, zstd and lz4 compressed files")
+	archiveSep := pflag.String("archive-separator", models.DefaultArchiveSeparator, "separator shown between an archive and the path of a file inside it")
+	archivePassword := pflag.String("archive-password", "", "password for encrypted zip, rar and 7z archives (default $FINDSTR_ARCHIVE_PASSWORD)")
+	archiveDepth := pflag.Int("archive-depth", 3, "how many levels of archives inside archives to open with --search-archives")
+	patterns := pflag.StringArray("pattern", nil, "pattern to search for; repeat to match any of several patterns")
+	patternsFile := pflag.String("patterns-file", "", "read patterns from a file, one per line")
+	regex := pflag.Bool("regex", false, "treat <pattern> as a regular expression (RE2 syntax)")
+	ignoreCase := pflag.BoolP("ignore-case", "i", false, "match case-insensitively (Unicode case folding)")
+	smartCase := pflag.Bool("smart-case", false, "match case-insensitively unless <pattern> contains an uppercase letter")
+	invertMatch := pflag.Bool("invert-match", false, "select the lines that don't match <pattern>")
+	filesWithoutMatch := pflag.Bool("files-without-match", false, "only list the files that have no match")
+	filesWithMatches := pflag.BoolP("files-with-matches", "l", false, "only list the files that have a match")
+	count := pflag.Bool("count", false, "only print the number of matching lines of each file")
+	countMatches := pflag.Bool("count-matches", false, "only print the number of matches of each file")
+	stats := pflag.Bool("stats", false, "print the files scanned, bytes read, matches and time taken to stderr")
+	maxCount := pflag.IntP("max-count", "m", 0, "stop reading a file after this many matching lines (0 = no limit)")
+	maxResults := pflag.Int("max-results", 0, "stop the search after this many matching lines in total, or files when listing or counting (0 = no limit)")
+	replace := pflag.String("replace", "", "show each match replaced with this text; with --regex, $1 or ${name} insert capture groups")
+	write := pflag.Bool("write", false, "with --replace, rewrite the matched files in place (archive members and compressed files are skipped)")
+	wordRegexp := pflag.BoolP("word-regexp", "w", false, "only match whole words, delimited by non-word characters or the line ends")
+	lineRegexp := pflag.BoolP("line-regexp", "X", false, "only match whole lines")
+	quietErrors := pflag.Bool("quiet-errors", false, "don't print files that couldn't be searched, only a summary at the end")
 	jsonOut := pflag.Bool("json", false, "print result in json format")
+	jsonLines := pflag.Bool("json-lines", false, "stream results as JSON Lines events (begin, match, context, end, summary)")
 	createConfig := pflag.Bool("create-config", false, "create default config at $HOME/.config/findstr.toml and exit")
 
 	pflag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: findstr [flags] <pattern>")
+		fmt.Fprintln(os.Stderr, "       findstr [flags] --pattern <pattern>... | --patterns-file <file>")
 		fmt.Fprintln(os.Stderr, "Search for file content matching <pattern> under the given root.")
 		fmt.Fprintln(os.Stderr)
 		pflag.PrintDefaults()
@@ -124,19 +188,94 @@ func parseFlags() (bool, *string, *string, int, int, *string, string, bool, bool
 
 	pflag.Parse()
 
-	if args := pflag.Args(); len(args) == 0 {
-		if *showVersion {
-			return *showVersion, nil, nil, 0, 0, nil, "", false, false, false, false, nil
+	if *showVersion || *createConfig {
+		return models.ProgramFlags{}, *showVersion, *createConfig, nil
+	}
+
+	var pats []string
+	if args := pflag.Args(); len(args) > 0 {
+		pats = append(pats, args[0])
+	}
+	pats = append(pats, *patterns...)
+	if *patternsFile != "" {
+		lines, err := utils.ReadFileLines(*patternsFile)
+		if err != nil {
+			return models.ProgramFlags{}, false, false, fmt.Errorf("unable to read patterns file: %w", err)
 		}
-		if *createConfig {
-			return false, nil, nil, 0, 0, nil, "", false, false, false, *createConfig, nil
+		for _, l := range lines {
+			if l = strings.TrimSuffix(l, "\r"); l != "" {
+				pats = append(pats, l)
+			}
 		}
-		return false, nil, nil, 0, -1, nil, "", false, false, false, false, errors.New(
+	}
+	if len(pats) == 0 {
+		return models.ProgramFlags{}, false, false, errors.New(
 			"you must provide a <pattern> to search for",
 		)
-	} else {
-		return *showVersion, exdir, exfile, *threadc, *context, root, args[0], *skipGit, *searchArch, *jsonOut, *createConfig, nil
 	}
+
+	replacing := pflag.CommandLine.Changed("replace")
+	if *write && !replacing {
+		return models.ProgramFlags{}, false, false, errors.New("--write requires --replace")
+	}
+	if *filesWithMatches && *filesWithoutMatch {
+		return models.ProgramFlags{}, false, false, errors.New("--files-with-matches and --files-without-match can't be used together")
+	}
+	if replacing && *invertMatch {
+		return models.ProgramFlags{}, false, false, errors.New("--replace can't be used with --invert-match")
+	}
+	if !models.ValidArchiveSeparator(*archiveSep) {
+		return models.ProgramFlags{}, false, false, fmt.Errorf(`--archive-separator %q could occur in a path; it must contain "//" or "/./"`, *archiveSep)
+	}
+
+	if !pflag.CommandLine.Changed("before-context") {
+		*beforeContext = *context
+	}
+	if !pflag.CommandLine.Changed("after-context") {
+		*afterContext = *context
+	}
+
+	if *archivePassword == "" {
+		*archivePassword = os.Getenv("FINDSTR_ARCHIVE_PASSWORD")
+	}
+
+	flags := models.ProgramFlags{
+		ExcludeDir:        *exdir,
+		ExcludeFile:       *exfile,
+		ThreadCount:       *threadc,
+		BeforeContext:     *beforeContext,
+		AfterContext:      *afterContext,
+		MaxColumns:        *maxColumns,
+		Root:              *root,
+		SkipGit:           *skipGit,
+		SearchArch:        *searchArch,
+		SearchZip:         *searchZip,
+		ArchiveDepth:      *archiveDepth,
+		ArchiveSep:        *archiveSep,
+		ArchivePassword:   *archivePassword,
+		NoIgnore:          *noIgnore,
+		QuietErrors:       *quietErrors,
+		Json:              *jsonOut,
+		JsonLines:         *jsonLines,
+		Regex:             *regex,
+		IgnoreCase:        *ignoreCase,
+		SmartCase:         *smartCase,
+		WordRegexp:        *wordRegexp,
+		LineRegexp:        *lineRegexp,
+		InvertMatch:       *invertMatch,
+		FilesWithoutMatch: *filesWithoutMatch,
+		FilesWithMatches:  *filesWithMatches,
+		Count:             *count,
+		CountMatches:      *countMatches,
+		Stats:             *stats,
+		MaxCount:          *maxCount,
+		MaxResults:        *maxResults,
+		Replace:           *replace,
+		Replacing:         replacing,
+		Write:             *write,
+		Patterns:          pats,
+	}
+	return flags, *showVersion, *createConfig, nil
 }
 
 func printVersion() {
@@ -2,11 +2,12 @@ package mappers
 
 import (
 	"context"
+	"sort"
 
 	"github.com/HubertasVin/findstr/models"
 )
 
-func MapChanToJsonFile(ctx context.Context, input <-chan models.FileMatch) []models.JsonFileMatch {
+func MapChanToJsonFile(ctx context.Context, input <-chan models.FileMatch, sep string) []models.JsonFileMatch {
 	var res []models.JsonFileMatch
 	for {
 		select {
@@ -18,7 +19,10 @@ func MapChanToJsonFile(ctx context.Context, input <-chan models.FileMatch) []mod
 			}
 			lm := MapFileToLineContents(fm)
 			jfm := models.JsonFileMatch{
-				FileName:       fm.File,
+				JsonFileInfo:   MapFileToJsonInfo(fm, sep),
+				MatchedLines:   CountMatchedLines(fm),
+				Matches:        CountSubmatches(fm),
+				ChangedLines:   changedLines(fm),
 				MatchedContent: lm,
 			}
 			res = append(res, jfm)
@@ -26,14 +30,132 @@ func MapChanToJsonFile(ctx context.Context, input <-chan models.FileMatch) []mod
 	}
 }
 
+// MapFileToJsonInfo describes the file, rendering archive member paths
+// with sep between the archive and member parts.
+func MapFileToJsonInfo(fm models.FileMatch, sep string) models.JsonFileInfo {
+	return models.JsonFileInfo{
+		FileName:  fm.Path.Format(sep),
+		Archives:  fm.Path.Archives,
+		Container: fm.Path.Container(sep),
+		InnerPath: fm.Path.Path,
+		Size:      fm.Size,
+		ModTime:   fm.ModTime,
+	}
+}
+
+// MapFileToLineContents returns the matched and context lines of a file
+// in line order.
 func MapFileToLineContents(intput models.FileMatch) []models.LineContent {
 	res := []models.LineContent{}
-	for _, ln := range intput.MatchLineNums {
+	for _, line := range intput.Lines {
 		lm := models.LineContent{
-			LineNumber: ln + 1,
-			Content:    intput.FileContent[ln],
+			Kind:       "context",
+			LineNumber: line.Num + 1,
+			Content:    line.Text,
+		}
+		if line.IsMatch() {
+			lm.Kind = "match"
+			lm.PatternIds = patternIds(line.Submatches)
+			lm.Submatches = line.Submatches
+			if line.Replacement != nil {
+				lm.Replacement = &line.Replacement.Text
+			}
 		}
 		res = append(res, lm)
 	}
 	return res
 }
+
+// MapFileToJsonEvents turns one file's matches into the begin, match,
+// context and end events of the --json-lines stream.
+func MapFileToJsonEvents(fm models.FileMatch, sep string) []models.JsonEvent {
+	info := MapFileToJsonInfo(fm, sep)
+	events := make([]models.JsonEvent, 0, len(fm.Lines)+2)
+	events = append(events, models.JsonEvent{
+		Type: "begin",
+		Data: models.JsonBegin{JsonFileInfo: info},
+	})
+
+	for _, lc := range MapFileToLineContents(fm) {
+		events = append(events, models.JsonEvent{
+			Type: lc.Kind,
+			Data: models.JsonLine{FileName: info.FileName, LineContent: lc},
+		})
+	}
+
+	events = append(events, models.JsonEvent{
+		Type: "end",
+		Data: models.JsonEnd{
+			FileName:     info.FileName,
+			MatchedLines: CountMatchedLines(fm),
+			Matches:      CountSubmatches(fm),
+			ChangedLines: changedLines(fm),
+		},
+	})
+	return events
+}
+
+// CountMatchedLines is the number of match lines of a file.
+func CountMatchedLines(fm models.FileMatch) int {
+	n := 0
+	for _, line := range fm.Lines {
+		if line.IsMatch() {
+			n++
+		}
+	}
+	return n
+}
+
+// CountSubmatches is the number of occurrences on the match lines of a
+// file.
+func CountSubmatches(fm models.FileMatch) int {
+	n := 0
+	for _, line := range fm.Lines {
+		n += len(line.Submatches)
+	}
+	return n
+}
+
+// changedLines is the number of lines --write changed in the file.
+func changedLines(fm models.FileMatch) int {
+	if fm.Rewrite == nil {
+		return 0
+	}
+	return fm.Rewrite.ChangedLines
+}
+
+func patternIds(subs []models.Submatch) []int {
+	ids := make([]int, 0, 1)
+	seen := map[int]struct{}{}
+	for _, sm := range subs {
+		if _, ok := seen[sm.PatternId]; !ok {
+			seen[sm.PatternId] = struct{}{}
+			ids = append(ids, sm.PatternId)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// MapErrorToJsonEvent describes a file that couldn't be searched.
+func MapErrorToJsonEvent(e models.SearchError, sep string) models.JsonEvent {
+	return models.JsonEvent{Type: "error", Data: mapError(e, sep)}
+}
+
+// MapErrorsToJsonFile describes the files that couldn't be searched as
+// entries of the --json output.
+func MapErrorsToJsonFile(errs []models.SearchError, sep string) []models.JsonFileError {
+	res := make([]models.JsonFileError, 0, len(errs))
+	for _, e := range errs {
+		res = append(res, models.JsonFileError{Error: mapError(e, sep)})
+	}
+	return res
+}
+
+func mapError(e models.SearchError, sep string) models.JsonError {
+	return models.JsonError{
+		FileName: e.Path.Format(sep),
+		Kind:     string(e.Kind),
+		Message:  e.Err.Error(),
+	}
+}
@@ -22,9 +22,15 @@ type ThemeJSON struct {
 	Styles map[string]StyleJson `toml:"styles"`
 }
 
+// ArchiveJSON maps archive globs to the password to open them with.
+type ArchiveJSON struct {
+	Passwords map[string]string `toml:"passwords"`
+}
+
 type ConfigJSON struct {
-	Theme  ThemeJSON  `toml:"theme"`
-	Layout LayoutJSON `toml:"layout"`
+	Theme   ThemeJSON   `toml:"theme"`
+	Layout  LayoutJSON  `toml:"layout"`
+	Archive ArchiveJSON `toml:"archive"`
 }
 
 type VarKind uint8
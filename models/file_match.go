@@ -1,8 +1,56 @@
 package models
 
+import "time"
+
+// FileMatch holds the matched lines of a file together with their
+// surrounding context lines; the rest of the file is never retained.
 type FileMatch struct {
-	File            string
-	ContextLineNums []int
-	MatchLineNums   []int
-	FileContent     []string
+	Path    FilePath
+	Size    int64
+	ModTime time.Time
+	Lines   []Line // matched and context lines in ascending order
+	// Rewrite is the outcome of --write, or nil if the file wasn't
+	// rewritten.
+	Rewrite *Rewrite
+}
+
+// Rewrite describes a file rewritten by --write. Files that can't be
+// written, such as archive members, have Skipped set instead.
+type Rewrite struct {
+	ChangedLines int
+	Skipped      bool
+}
+
+// Line is a single output line. Num is the zero-based line index.
+// Matched is set on match lines; Submatches lists their occurrences and
+// is empty for context lines and lines matched by --invert-match.
+// Replacement is set on match lines with --replace.
+type Line struct {
+	Num         int
+	Text        string
+	Matched     bool
+	Submatches  []Submatch
+	Replacement *Replacement
+}
+
+// Replacement is a match line as --replace rewrites it. Spans are the
+// byte ranges of the inserted text.
+type Replacement struct {
+	Text  string
+	Spans []Submatch
+}
+
+func (l Line) IsMatch() bool {
+	return l.Matched
+}
+
+// Submatch is a single pattern occurrence within a line. Start and End
+// are byte offsets, RuneStart and RuneEnd the same span counted in runes.
+type Submatch struct {
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	RuneStart int    `json:"runeStart"`
+	RuneEnd   int    `json:"runeEnd"`
+	Text      string `json:"text"`
+	PatternId int    `json:"patternId"`
 }
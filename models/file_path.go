@@ -0,0 +1,82 @@
+package models
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultArchiveSeparator joins the parts of an archive member path when
+// it is displayed. Cleaned paths never contain it, so it cannot be
+// confused with a real file name the way "#" could.
+const DefaultArchiveSeparator = "//"
+
+// ValidArchiveSeparator reports whether sep can't be confused with part
+// of a path: it must contain something no cleaned path does, such as
+// "//", "/./" or a NUL byte.
+func ValidArchiveSeparator(sep string) bool {
+	return strings.Contains(sep, "//") || strings.Contains(sep, "/./") || strings.Contains(sep, "\x00")
+}
+
+// FilePath locates a searched file. Archives is the chain of archives the
+// file is nested in, outermost (the file on disk) first; Path is the
+// file's path inside the innermost archive, or on disk when Archives is
+// empty. Paths inside archives always use "/".
+type FilePath struct {
+	Archives []string
+	Path     string
+}
+
+func (p FilePath) InArchive() bool {
+	return len(p.Archives) > 0
+}
+
+// Container returns the chain of archives the file is in, rendered with
+// sep, or "" for files on disk.
+func (p FilePath) Container(sep string) string {
+	return strings.Join(p.Archives, sep)
+}
+
+// Format renders the full path with sep between archive and member parts.
+func (p FilePath) Format(sep string) string {
+	if !p.InArchive() {
+		return p.Path
+	}
+	return p.Container(sep) + sep + p.Path
+}
+
+// Dir returns the path of the directory containing the file.
+func (p FilePath) Dir() FilePath {
+	if !p.InArchive() {
+		return FilePath{Path: filepath.Dir(p.Path)}
+	}
+	return FilePath{Archives: p.Archives, Path: path.Dir(p.Path)}
+}
+
+// Base returns the last element of the file's path.
+func (p FilePath) Base() string {
+	if !p.InArchive() {
+		return filepath.Base(p.Path)
+	}
+	return path.Base(p.Path)
+}
+
+// Clean returns the path with every part cleaned.
+func (p FilePath) Clean() FilePath {
+	if !p.InArchive() {
+		return FilePath{Path: filepath.Clean(p.Path)}
+	}
+	archives := make([]string, len(p.Archives))
+	archives[0] = filepath.Clean(p.Archives[0])
+	for i := 1; i < len(p.Archives); i++ {
+		archives[i] = path.Clean(p.Archives[i])
+	}
+	return FilePath{Archives: archives, Path: path.Clean(p.Path)}
+}
+
+// Member returns the path of name inside the archive p.
+func (p FilePath) Member(name string) FilePath {
+	archives := make([]string, len(p.Archives), len(p.Archives)+1)
+	copy(archives, p.Archives)
+	return FilePath{Archives: append(archives, p.Path), Path: name}
+}
@@ -0,0 +1,45 @@
+package models
+
+// JsonEvent is a single record of the --json-lines output stream.
+// Type is one of "begin", "match", "context", "end", "error" or
+// "summary".
+type JsonEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type JsonBegin struct {
+	JsonFileInfo
+}
+
+type JsonLine struct {
+	FileName string `json:"fileName"`
+	LineContent
+}
+
+type JsonEnd struct {
+	FileName     string `json:"fileName"`
+	MatchedLines int    `json:"matchedLines"`
+	Matches      int    `json:"matches"`
+	ChangedLines int    `json:"changedLines,omitempty"`
+}
+
+type JsonError struct {
+	FileName string `json:"fileName"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+}
+
+// JsonFileError is the entry of the --json output for a path that
+// couldn't be searched.
+type JsonFileError struct {
+	Error JsonError `json:"error"`
+}
+
+type JsonSummary struct {
+	Files        int     `json:"files"`
+	MatchedLines int     `json:"matchedLines"`
+	Matches      int     `json:"matches"`
+	Errors       int     `json:"errors"`
+	ElapsedMs    float64 `json:"elapsedMs"`
+}
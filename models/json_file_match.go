@@ -1,11 +1,35 @@
 package models
 
+import "time"
+
 type JsonFileMatch struct {
-	FileName       string        `json:"fileName"`
+	JsonFileInfo
+	MatchedLines   int           `json:"matchedLines"`
+	Matches        int           `json:"matches"`
+	ChangedLines   int           `json:"changedLines,omitempty"`
 	MatchedContent []LineContent `json:"matchedContent"`
 }
 
+// JsonFileInfo describes the searched file. For archive members Archives
+// is the chain of archives it is nested in, outermost first, Container the
+// same chain rendered as one path and InnerPath the member's path inside
+// the innermost archive.
+type JsonFileInfo struct {
+	FileName  string    `json:"fileName"`
+	Archives  []string  `json:"archives,omitempty"`
+	Container string    `json:"container,omitempty"`
+	InnerPath string    `json:"innerPath"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+}
+
+// LineContent is a matched or context line; Kind is "match" or "context".
+// Replacement is the rewritten content of a match line with --replace.
 type LineContent struct {
-	LineNumber int    `json:"lineNumber"`
-	Content    string `json:"content"`
+	Kind        string     `json:"kind"`
+	LineNumber  int        `json:"lineNumber"`
+	Content     string     `json:"content"`
+	Replacement *string    `json:"replacement,omitempty"`
+	PatternIds  []int      `json:"patternIds,omitempty"`
+	Submatches  []Submatch `json:"submatches,omitempty"`
 }
@@ -1,13 +1,39 @@
 package models
 
 type ProgramFlags struct {
-	ExcludeDir  string
-	ExcludeFile string
-	ThreadCount int
-	ContextSize int
-	Root        string
-	SkipGit     bool
-	SearchArch  bool
-    Json        bool
-	Pattern     string
+	ExcludeDir        string
+	ExcludeFile       string
+	ThreadCount       int
+	BeforeContext     int
+	AfterContext      int
+	MaxColumns        int
+	Root              string
+	SkipGit           bool
+	SearchArch        bool
+	SearchZip         bool
+	ArchiveDepth      int
+	ArchiveSep        string
+	ArchivePassword   string
+	ArchivePasswords  map[string]string
+	NoIgnore          bool
+	QuietErrors       bool
+	Json              bool
+	JsonLines         bool
+	Regex             bool
+	IgnoreCase        bool
+	SmartCase         bool
+	WordRegexp        bool
+	LineRegexp        bool
+	InvertMatch       bool
+	FilesWithoutMatch bool
+	FilesWithMatches  bool
+	Count             bool
+	CountMatches      bool
+	Stats             bool
+	MaxCount          int
+	MaxResults        int
+	Replace           string
+	Replacing         bool
+	Write             bool
+	Patterns          []string
 }
@@ -0,0 +1,31 @@
+package models
+
+// ErrorKind categorizes why a file or directory couldn't be searched.
+type ErrorKind string
+
+const (
+	ErrorPermission  ErrorKind = "permission"
+	ErrorDecode      ErrorKind = "decode"
+	ErrorTooLongLine ErrorKind = "too-long-line"
+	ErrorArchive     ErrorKind = "archive"
+	ErrorIO          ErrorKind = "io"
+)
+
+// ErrorKinds lists the kinds in the order they are summarized.
+var ErrorKinds = []ErrorKind{ErrorPermission, ErrorDecode, ErrorTooLongLine, ErrorArchive, ErrorIO}
+
+// SearchError is a file, directory or archive member that couldn't be
+// searched. It doesn't stop the search.
+type SearchError struct {
+	Path FilePath
+	Kind ErrorKind
+	Err  error
+}
+
+func (e SearchError) Error() string {
+	return e.Path.Format(DefaultArchiveSeparator) + ": " + e.Err.Error()
+}
+
+func (e SearchError) Unwrap() error {
+	return e.Err
+}
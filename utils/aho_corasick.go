@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"unicode/utf8"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// ahoCorasick is a rune based Aho-Corasick automaton used to find many
+// literal patterns in a single pass over a line. When fold is set the
+// patterns and the scanned text are compared under Unicode simple case
+// folding. Empty patterns are not stored; callers handle them.
+type ahoCorasick struct {
+	nodes   []acNode
+	lengths []int // pattern lengths in runes, indexed by pattern id
+	fold    bool
+}
+
+type acNode struct {
+	next     map[rune]int32
+	fail     int32
+	dict     int32 // nearest node on the fail chain that ends a pattern, -1 if none
+	patterns []int // ids of the patterns ending exactly at this node
+}
+
+func newAhoCorasick(patterns []string, fold bool) *ahoCorasick {
+	ac := &ahoCorasick{
+		nodes:   []acNode{{fail: 0, dict: -1}},
+		lengths: make([]int, len(patterns)),
+		fold:    fold,
+	}
+
+	for id, p := range patterns {
+		if p == "" {
+			continue
+		}
+		cur := int32(0)
+		n := 0
+		for _, r := range p {
+			if fold {
+				r = foldRune(r)
+			}
+			n++
+			nxt, ok := ac.nodes[cur].next[r]
+			if !ok {
+				nxt = int32(len(ac.nodes))
+				ac.nodes = append(ac.nodes, acNode{dict: -1})
+				if ac.nodes[cur].next == nil {
+					ac.nodes[cur].next = make(map[rune]int32)
+				}
+				ac.nodes[cur].next[r] = nxt
+			}
+			cur = nxt
+		}
+		ac.nodes[cur].patterns = append(ac.nodes[cur].patterns, id)
+		ac.lengths[id] = n
+	}
+
+	// Breadth-first construction of the failure and dictionary links.
+	queue := make([]int32, 0, len(ac.nodes))
+	for _, child := range ac.nodes[0].next {
+		ac.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range ac.nodes[cur].next {
+			f := ac.nodes[cur].fail
+			for {
+				if nxt, ok := ac.nodes[f].next[r]; ok {
+					ac.nodes[child].fail = nxt
+					break
+				}
+				if f == 0 {
+					ac.nodes[child].fail = 0
+					break
+				}
+				f = ac.nodes[f].fail
+			}
+			fail := ac.nodes[child].fail
+			if len(ac.nodes[fail].patterns) > 0 {
+				ac.nodes[child].dict = fail
+			} else {
+				ac.nodes[child].dict = ac.nodes[fail].dict
+			}
+			queue = append(queue, child)
+		}
+	}
+	return ac
+}
+
+// scan appends every pattern occurrence in s to out, including
+// overlapping ones, in order of their end offset.
+func (ac *ahoCorasick) scan(s string, out []models.Submatch) []models.Submatch {
+	var runeStarts []int
+	cur := int32(0)
+	ri := 0
+	for i, r := range s {
+		runeStarts = append(runeStarts, i)
+		if ac.fold {
+			r = foldRune(r)
+		}
+		for {
+			if nxt, ok := ac.nodes[cur].next[r]; ok {
+				cur = nxt
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = ac.nodes[cur].fail
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		ri++
+		for n := cur; n > 0; n = ac.nodes[n].dict {
+			for _, id := range ac.nodes[n].patterns {
+				rs := ri - ac.lengths[id]
+				out = append(out, models.Submatch{
+					Start:     runeStarts[rs],
+					End:       i + size,
+					RuneStart: rs,
+					RuneEnd:   ri,
+					Text:      s[runeStarts[rs] : i+size],
+					PatternId: id,
+				})
+			}
+		}
+	}
+	return out
+}
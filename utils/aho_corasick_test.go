@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// bruteFindAll is the reference for the literal matchers: the leftmost
+// non-overlapping occurrences of each pattern that fit bound, found by
+// trying every start, ordered like sortSubmatches.
+func bruteFindAll(line string, patterns []string, fold bool, bound boundary) []models.Submatch {
+	var subs []models.Submatch
+	for id, p := range patterns {
+		if p == "" {
+			// An empty pattern matches once, at the first offset that fits.
+			for pos := 0; pos <= len(line); pos++ {
+				if pos < len(line) && !utf8.RuneStart(line[pos]) {
+					continue
+				}
+				if bound.fits(line, pos, pos) {
+					subs = append(subs, models.Submatch{Start: pos, End: pos, PatternId: id})
+					break
+				}
+			}
+			continue
+		}
+		lastEnd := 0
+		for start := 0; start < len(line); {
+			end, ok := matchAt(line, start, p, fold)
+			if ok && start >= lastEnd && bound.fits(line, start, end) {
+				subs = append(subs, models.Submatch{Start: start, End: end, Text: line[start:end], PatternId: id})
+				lastEnd = end
+			}
+			_, size := utf8.DecodeRuneInString(line[start:])
+			start += size
+		}
+	}
+	sortSubmatches(subs)
+	return setRuneOffsets(line, subs)
+}
+
+// matchAt reports whether p occurs at line[start:], rune by rune, and
+// where the occurrence ends.
+func matchAt(line string, start int, p string, fold bool) (int, bool) {
+	if !fold {
+		return start + len(p), strings.HasPrefix(line[start:], p)
+	}
+	pos := start
+	for _, pr := range p {
+		if pos == len(line) {
+			return 0, false
+		}
+		lr, size := utf8.DecodeRuneInString(line[pos:])
+		if foldRune(lr) != foldRune(pr) {
+			return 0, false
+		}
+		pos += size
+	}
+	return pos, true
+}
+
+func TestLiteralMatchersAgainstBruteForce(t *testing.T) {
+	// Runes whose case folding changes their UTF-8 length, and word and
+	// non-word runes for the boundaries.
+	alphabet := []string{"a", "A", "b", "s", "S", "ſ", "k", "K", "K", "é", "É", " ", "_", "."}
+	word := func(rng *rand.Rand, n int) string {
+		var b strings.Builder
+		for range n {
+			b.WriteString(alphabet[rng.IntN(len(alphabet))])
+		}
+		return b.String()
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 50000 {
+		flags := models.ProgramFlags{
+			IgnoreCase: rng.IntN(2) == 0,
+			WordRegexp: rng.IntN(3) == 0,
+		}
+		for range 1 + rng.IntN(4) {
+			n := 1 + rng.IntN(3)
+			if rng.IntN(20) == 0 {
+				n = 0
+			}
+			flags.Patterns = append(flags.Patterns, word(rng, n))
+		}
+		line := word(rng, rng.IntN(14))
+
+		matcher, err := NewMatcher(flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bound := anyBoundary
+		if flags.WordRegexp {
+			bound = wordBoundary
+		}
+		got := matcher.FindAll(line)
+		want := bruteFindAll(line, flags.Patterns, flags.IgnoreCase, bound)
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("case %d: FindAll(%q) with patterns %q, ignore case %v, words %v\n got  %+v\n want %+v",
+				i, line, flags.Patterns, flags.IgnoreCase, flags.WordRegexp, got, want)
+		}
+	}
+}
@@ -1,24 +1,95 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/HubertasVin/findstr/models"
+	"github.com/bodgit/sevenzip"
 )
 
 type ArchiveHandler interface {
 	CanHandle(fileName string) bool
-	Iterate(archPath string, callback func(name string, isDir bool) error) error
-	ReadFile(archPath, targetPath string) (io.ReadCloser, error)
+	// Walk streams the archive once, calling callback with the content of
+	// every file member in archive order. r is only valid during the call.
+	// If a member can't be opened, r returns the error on the first read,
+	// so one encrypted member doesn't end the walk.
+	Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error
+}
+
+// Source is the content of an archive: either a file on disk or an
+// archive member that was read into memory to search nested archives.
+type Source struct {
+	Name     string // file name, used to pick the handler
+	R        io.ReaderAt
+	Size     int64
+	Password string // used for encrypted archives and members
+}
+
+// PasswordFunc returns the password to try for the archive called name,
+// or "" if there is none.
+type PasswordFunc func(name string) string
+
+// ErrPassword is returned when an encrypted archive or member can't be
+// opened with the password given, or no password was given.
+var ErrPassword = errors.New("incorrect or missing archive password")
+
+// IsPasswordError reports whether err came from a missing or wrong
+// password rather than a damaged archive.
+func IsPasswordError(err error) bool {
+	if errors.Is(err, ErrPassword) {
+		return true
+	}
+	var readErr *sevenzip.ReadError
+	return errors.As(err, &readErr) && readErr.Encrypted
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
 }
 
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// reader returns a fresh sequential reader over the whole source.
+func (s Source) reader() io.Reader {
+	return io.NewSectionReader(s.R, 0, s.Size)
+}
+
+// ArchiveSniffer is implemented by handlers that can recognize their
+// format from the start of the content, so archives with an unknown or
+// wrong extension are still opened by the right handler.
+type ArchiveSniffer interface {
+	// Sniff reports whether header, the first SniffLen bytes of the
+	// content (or all of it, if shorter), is in the handler's format.
+	Sniff(header []byte) bool
+}
+
+// SniffLen is how much of the content is needed to recognize an archive.
+// It covers the ISO9660 volume descriptor, the furthest signature from
+// the start of any supported format.
+const SniffLen = isoMagicOffset + len(isoMagic)
+
+// sniffBufSize is the buffer used to peek at members. It matches the
+// search's read buffer, so the member is not buffered twice.
+const sniffBufSize = 64 * 1024
+
 var archiveHandlers = []ArchiveHandler{
 	&ZipHandler{},
 	&TarHandler{},
 	&RarHandler{},
 	&SevenZipHandler{},
+	&CpioHandler{},
+	&ArHandler{},
+	&IsoHandler{},
 }
 
 // IsCompatibleArchive checks if a file is a supported archive
@@ -31,67 +102,133 @@ func IsCompatibleArchive(fileName string) bool {
 	return false
 }
 
-// IsPathInArchive checks if a path contains archive separator
-func IsPathInArchive(path string) bool {
-	return strings.Contains(path, "#")
+// SkipFunc reports whether an archive member should be left out. name is
+// the cleaned member path, relative to the archive it is stored in.
+type SkipFunc func(name string) bool
+
+// WalkArchive reads the archive at archPath in a single pass and calls
+// callback for every file in it with its path, metadata and content.
+// Members skip reports are neither read nor descended into. Archives
+// found inside it are descended into while fewer than maxDepth archives
+// are open. Encrypted archives are opened with the password password
+// returns for them.
+func WalkArchive(archPath models.FilePath, maxDepth int, skip SkipFunc, password PasswordFunc, callback func(p models.FilePath, info fs.FileInfo, r io.Reader) error) error {
+	file, err := os.Open(archPath.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	src := Source{Name: archPath.Path, R: file, Size: info.Size(), Password: passwordFor(password, archPath.Path)}
+	return walkArchive(src, archPath, maxDepth, skip, password, callback)
 }
 
-// GetArchiveFiles lists all files in an archive
-func GetArchiveFiles(archPath, excludeDir, excludeFile string, skipGit, searchArch bool) ([]string, error) {
-	handler := getHandler(archPath)
+func walkArchive(src Source, arch models.FilePath, depth int, skip SkipFunc, password PasswordFunc, callback func(p models.FilePath, info fs.FileInfo, r io.Reader) error) error {
+	handler := getHandler(src)
 	if handler == nil {
-		return nil, fmt.Errorf("unsupported archive format: %s", archPath)
+		return fmt.Errorf("unsupported archive format: %s", src.Name)
 	}
 
-	var files []string
-	err := handler.Iterate(archPath, func(name string, isDir bool) error {
-		if !isDir {
-			files = append(files, combineArchivePath(archPath, name))
+	return handler.Walk(src, func(name string, info fs.FileInfo, r io.Reader) error {
+		clean := cleanMemberName(name)
+		if skip(clean) {
+			return nil
+		}
+		member := arch.Member(clean)
+		if depth <= 1 {
+			return callback(member, info, r)
+		}
+		if !IsCompatibleArchive(name) {
+			// Members without an archive extension are peeked at, so
+			// nested archives are found by their content too.
+			br := bufio.NewReaderSize(r, sniffBufSize)
+			header, _ := br.Peek(SniffLen)
+			if sniffHandler(header) == nil {
+				return callback(member, info, br)
+			}
+			r = br
+		}
+
+		// A nested archive that can't be read is reported as the
+		// member's error, and the rest of the outer archive is still
+		// walked.
+		data, err := io.ReadAll(io.LimitReader(r, maxNestedSize+1))
+		if err != nil {
+			return callback(member, info, errReader{err})
+		}
+		if int64(len(data)) > maxNestedSize {
+			return callback(member, info, errReader{&NestedArchiveError{Err: errNestedTooLarge}})
+		}
+		inner := Source{Name: name, R: bytes.NewReader(data), Size: int64(len(data)), Password: passwordFor(password, name)}
+		if err := walkArchive(inner, member, depth-1, skip, password, callback); err != nil {
+			return callback(member, info, errReader{&NestedArchiveError{Err: err}})
 		}
 		return nil
 	})
-
-	return files, err
 }
 
-// ReadArchiveFileLines reads lines from a file within an archive
-func ReadArchiveFileLines(path string) ([]string, error) {
-	paths := strings.Split(path, "#")
-	if len(paths) != 2 {
-		return nil, fmt.Errorf("invalid archive path format: %s", path)
-	}
+// maxNestedSize bounds the nested archives read into memory, so a large
+// image or a zip bomb inside an archive can't exhaust it.
+var maxNestedSize int64 = 256 << 20
 
-	handler := getHandler(paths[0])
-	if handler == nil {
-		return nil, fmt.Errorf("unsupported archive format: %s", paths[0])
-	}
+var errNestedTooLarge = fmt.Errorf("nested archive too large (over %d MiB)", maxNestedSize>>20)
 
-	targetPath := filepath.ToSlash(paths[1])
-	rc, err := handler.ReadFile(paths[0], targetPath)
-	if err != nil {
-		return nil, err
+// NestedArchiveError is the error of a nested archive that couldn't be
+// walked, read from the member in its place.
+type NestedArchiveError struct {
+	Err error
+}
+
+func (e *NestedArchiveError) Error() string { return e.Err.Error() }
+func (e *NestedArchiveError) Unwrap() error { return e.Err }
+
+func passwordFor(password PasswordFunc, name string) string {
+	if password == nil {
+		return ""
 	}
-	defer rc.Close()
+	return password(name)
+}
 
-	return readLines(rc)
+// cleanMemberName normalizes a member name as stored in an archive, so
+// "./a.txt", "/a.txt" and "a.txt" all refer to the same member.
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
 }
 
-// Helper functions
-func combineArchivePath(archivePath, internalPath string) string {
-	archivePath = filepath.Clean(archivePath)
-	internalPath = path.Clean(internalPath)
-	internalPath = strings.TrimPrefix(internalPath, "/")
+// getHandler picks the handler for src by its content, so misnamed and
+// extension-less archives are read correctly, falling back to its name
+// for formats without a reliable signature.
+func getHandler(src Source) ArchiveHandler {
+	header := make([]byte, SniffLen)
+	n, _ := src.R.ReadAt(header, 0)
+	if handler := sniffHandler(header[:n]); handler != nil {
+		return handler
+	}
 
-	if internalPath == "" || internalPath == "." {
-		return archivePath
+	for _, handler := range archiveHandlers {
+		if handler.CanHandle(src.Name) {
+			return handler
+		}
 	}
+	return nil
+}
 
-	return archivePath + "#" + internalPath
+// SniffArchive reports whether header, the first SniffLen bytes of some
+// content (or all of it, if shorter), is the start of a supported archive.
+func SniffArchive(header []byte) bool {
+	return sniffHandler(header) != nil
 }
 
-func getHandler(fileName string) ArchiveHandler {
+// sniffHandler returns the handler that recognizes header as the start
+// of its format, or nil.
+func sniffHandler(header []byte) ArchiveHandler {
 	for _, handler := range archiveHandlers {
-		if handler.CanHandle(fileName) {
+		if sniffer, ok := handler.(ArchiveSniffer); ok && sniffer.Sniff(header) {
 			return handler
 		}
 	}
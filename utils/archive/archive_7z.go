@@ -1,9 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"path/filepath"
+	"io/fs"
 	"strings"
 
 	"github.com/bodgit/sevenzip"
@@ -15,57 +16,32 @@ func (s *SevenZipHandler) CanHandle(fileName string) bool {
 	return strings.HasSuffix(strings.ToLower(fileName), ".7z")
 }
 
-func (s *SevenZipHandler) Iterate(archPath string, callback func(name string, isDir bool) error) error {
-	reader, err := sevenzip.OpenReader(archPath)
-	if err != nil {
-		return fmt.Errorf("failed to open 7z: %w", err)
-	}
-	defer reader.Close()
-
-	for _, file := range reader.File {
-		isDir := file.FileHeader.FileInfo().IsDir()
-		if err := callback(file.Name, isDir); err != nil {
-			return err
-		}
-	}
-	return nil
+func (s *SevenZipHandler) Sniff(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("7z\xbc\xaf\x27\x1c"))
 }
 
-func (s *SevenZipHandler) ReadFile(archPath, targetPath string) (io.ReadCloser, error) {
-	reader, err := sevenzip.OpenReader(archPath)
+func (s *SevenZipHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	reader, err := sevenzip.NewReaderWithPassword(src.R, src.Size, src.Password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open 7z: %w", err)
+		return fmt.Errorf("failed to open 7z: %w", err)
 	}
 
 	for _, file := range reader.File {
-		if filepath.ToSlash(file.Name) == targetPath {
-			if file.FileInfo().IsDir() {
-				reader.Close()
-				return nil, fmt.Errorf("path is a directory")
-			}
-			rc, err := file.Open()
-			if err != nil {
-				reader.Close()
-				return nil, err
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			if err := callback(file.Name, file.FileInfo(), errReader{err}); err != nil {
+				return err
 			}
-			return &sevenZipFileReader{rc: rc, zr: reader}, nil
+			continue
+		}
+		err = callback(file.Name, file.FileInfo(), rc)
+		rc.Close()
+		if err != nil {
+			return err
 		}
 	}
-
-	reader.Close()
-	return nil, fmt.Errorf("file %s not found in archive", targetPath)
-}
-
-type sevenZipFileReader struct {
-	rc io.ReadCloser
-	zr *sevenzip.ReadCloser
-}
-
-func (s *sevenZipFileReader) Read(p []byte) (n int, err error) {
-	return s.rc.Read(p)
-}
-
-func (s *sevenZipFileReader) Close() error {
-	s.rc.Close()
-	return s.zr.Close()
+	return nil
 }
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArHandler reads Unix ar archives: static libraries and Debian packages,
+// whose control and data tarballs are searched as nested archives. Both
+// the GNU and BSD long name extensions are supported.
+type ArHandler struct{}
+
+const arMagic = "!<arch>\n"
+
+var arExtensions = []string{".ar", ".deb", ".udeb"}
+
+func (a *ArHandler) CanHandle(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	for _, ext := range arExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ArHandler) Sniff(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(arMagic))
+}
+
+func (a *ArHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	return walkEntries(arEntries, src, callback)
+}
+
+func arEntries(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	cr := &countingReader{r: bufio.NewReader(src.reader())}
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(cr, magic); err != nil || string(magic) != arMagic {
+		return fmt.Errorf("not an ar archive")
+	}
+
+	var longNames []byte
+	header := make([]byte, 60)
+	for {
+		if err := cr.skipToAlign(2); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err := io.ReadFull(cr, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if string(header[58:60]) != "`\n" {
+			return fmt.Errorf("invalid ar header")
+		}
+
+		name := strings.TrimRight(string(header[0:16]), " ")
+		mtime, _ := strconv.ParseInt(strings.TrimSpace(string(header[16:28])), 10, 64)
+		mode, _ := strconv.ParseInt(strings.TrimSpace(string(header[40:48])), 8, 64)
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ar header: %w", err)
+		}
+		if size < 0 {
+			return fmt.Errorf("invalid ar member size %d", size)
+		}
+		data := io.LimitReader(cr, size)
+
+		switch {
+		case name == "/" || name == "/SYM64/" || name == "__.SYMDEF" || name == "__.SYMDEF SORTED":
+			// Symbol tables aren't members.
+			name = ""
+		case name == "//":
+			if longNames, err = io.ReadAll(data); err != nil {
+				return err
+			}
+			name = ""
+		case strings.HasPrefix(name, "#1/"):
+			// BSD: the name is stored at the start of the data.
+			n, err := strconv.ParseInt(name[3:], 10, 64)
+			if err != nil || n < 0 || n > size || n > maxNameLen {
+				return fmt.Errorf("invalid ar member name %q", name)
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(data, buf); err != nil {
+				return err
+			}
+			name = string(bytes.TrimRight(buf, "\x00"))
+			size -= n
+		case len(name) > 1 && name[0] == '/':
+			// GNU: the name is at an offset into the long names table.
+			off, err := strconv.Atoi(name[1:])
+			if err != nil || off < 0 || off >= len(longNames) {
+				return fmt.Errorf("invalid ar member name %q", name)
+			}
+			name = string(longNames[off:])
+			if i := strings.Index(name, "/\n"); i >= 0 {
+				name = name[:i]
+			}
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		if name != "" {
+			info := memberInfo{
+				name:    name,
+				size:    size,
+				mode:    fs.FileMode(mode & 0o777),
+				modTime: time.Unix(mtime, 0),
+			}
+			if err := callback(name, info, data); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+)
+
+// arMember formats a member header with the given name and size fields,
+// followed by data and its padding.
+func arMember(name, size, data string) string {
+	h := fmt.Sprintf("%-16s%-12s%-6s%-6s%-8s%-10s`\n", name, "0", "0", "0", "644", size)
+	if len(data)%2 == 1 {
+		data += "\n"
+	}
+	return h + data
+}
+
+func arArchive(members ...string) []byte {
+	return []byte(arMagic + strings.Join(members, ""))
+}
+
+func TestArEntries(t *testing.T) {
+	long := "a_rather_long_member_name.txt"
+	tests := []struct {
+		name    string
+		archive []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "plain",
+			archive: arArchive(arMember("a.txt/", "3", "abc"), arMember("b.txt/", "2", "de")),
+			want:    map[string]string{"a.txt": "abc", "b.txt": "de"},
+		},
+		{
+			name: "gnu long name",
+			archive: arArchive(
+				arMember("//", fmt.Sprint(len(long)+2), long+"/\n"),
+				arMember("/0", "2", "hi"),
+			),
+			want: map[string]string{long: "hi"},
+		},
+		{
+			name:    "bsd long name",
+			archive: arArchive(arMember("#1/"+fmt.Sprint(len(long)), fmt.Sprint(len(long)+2), long+"hi")),
+			want:    map[string]string{long: "hi"},
+		},
+		{name: "not ar", archive: []byte("hello"), wantErr: true},
+		{name: "negative size", archive: arArchive(arMember("a.txt/", "-1", "")), wantErr: true},
+		{name: "bad size", archive: arArchive(arMember("a.txt/", "x", "")), wantErr: true},
+		{name: "negative bsd name length", archive: arArchive(arMember("#1/-1", "4", "abcd")), wantErr: true},
+		{name: "bsd name past data", archive: arArchive(arMember("#1/9", "4", "abcd")), wantErr: true},
+		{name: "huge bsd name", archive: arArchive(arMember("#1/999999999", "9999999999", "")), wantErr: true},
+		{name: "negative gnu offset", archive: arArchive(arMember("//", "2", "x\n"), arMember("/-1", "0", "")), wantErr: true},
+		{name: "gnu offset past table", archive: arArchive(arMember("/5", "0", "")), wantErr: true},
+		{name: "bad terminator", archive: []byte(arMagic + strings.Repeat(" ", 60)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walkMembers(&ArHandler{}, "t.ar", tt.archive)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("members = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzArEntries(f *testing.F) {
+	f.Add(arArchive(arMember("a.txt/", "3", "abc")))
+	f.Add(arArchive(arMember("#1/4", "6", "namehi")))
+	f.Add(arArchive(arMember("//", "4", "ab/\n"), arMember("/0", "1", "x")))
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		walkMembers(&ArHandler{}, "t.ar", archive)
+	})
+}
@@ -1,26 +1,12 @@
 package utils
 
 import (
-	"bufio"
-	"fmt"
 	"io"
+	"io/fs"
+	"path"
+	"time"
 )
 
-func readLines(r io.Reader) ([]string, error) {
-	lines := make([]string, 0)
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 0), bufio.MaxScanTokenSize)
-
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
-	}
-	return lines, nil
-}
-
 type nopCloser struct {
 	io.Reader
 }
@@ -30,3 +16,37 @@ func (nopCloser) Close() error { return nil }
 func NopCloser(r io.Reader) io.ReadCloser {
 	return nopCloser{r}
 }
+
+// maxNameLen bounds the member names read from headers that store their
+// length, well above any real path.
+const maxNameLen = 64 * 1024
+
+// memberInfo is the fs.FileInfo of a member of a format without its own
+// header type.
+type memberInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memberInfo) Name() string       { return path.Base(i.name) }
+func (i memberInfo) Size() int64        { return i.size }
+func (i memberInfo) Mode() fs.FileMode  { return i.mode }
+func (i memberInfo) ModTime() time.Time { return i.modTime }
+func (i memberInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memberInfo) Sys() any           { return nil }
+
+// entryFunc visits every entry of an archive in order, directories
+// included. r is only valid during the call.
+type entryFunc func(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error
+
+// walkEntries implements ArchiveHandler.Walk on top of an entryFunc.
+func walkEntries(each entryFunc, src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	return each(src, func(name string, info fs.FileInfo, r io.Reader) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return callback(name, info, r)
+	})
+}
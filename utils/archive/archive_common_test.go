@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+// walkMembers reads every member of archive with handler and returns
+// their contents by name.
+func walkMembers(handler ArchiveHandler, name string, archive []byte) (map[string]string, error) {
+	members := make(map[string]string)
+	src := Source{Name: name, R: bytes.NewReader(archive), Size: int64(len(archive))}
+	err := handler.Walk(src, func(name string, info fs.FileInfo, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		members[name] = string(data)
+		return nil
+	})
+	return members, err
+}
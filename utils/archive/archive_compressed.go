@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// compressedFormats are the single-file compression formats that can be
+// searched transparently, recognized by their signature. The extensions
+// are used for the names of compressed archives, such as .cpio.gz.
+var compressedFormats = []struct {
+	ext   string
+	magic []byte
+	open  func(r io.Reader) (io.ReadCloser, error)
+}{
+	{".gz", []byte{0x1f, 0x8b}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	{".bz2", []byte("BZh"), func(r io.Reader) (io.ReadCloser, error) {
+		return NopCloser(bzip2.NewReader(r)), nil
+	}},
+	{".xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, func(r io.Reader) (io.ReadCloser, error) {
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return NopCloser(xzReader), nil
+	}},
+	{".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.ReadCloser, error) {
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	}},
+	{".lz4", []byte{0x04, 0x22, 0x4d, 0x18}, func(r io.Reader) (io.ReadCloser, error) {
+		return NopCloser(lz4.NewReader(r)), nil
+	}},
+}
+
+// IsCompressed reports whether header, the start of some content, is the
+// signature of a supported compression format.
+func IsCompressed(header []byte) bool {
+	for _, f := range compressedFormats {
+		if bytes.HasPrefix(header, f.magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDecompressor returns the decompressed content of r if it starts with
+// the signature of a supported compression format, and r as is otherwise.
+// Closing it does not close r.
+func NewDecompressor(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, sniffBufSize)
+	header, _ := br.Peek(8)
+	for _, f := range compressedFormats {
+		if bytes.HasPrefix(header, f.magic) {
+			return f.open(br)
+		}
+	}
+	return NopCloser(br), nil
+}
+
+// decompressedHeader returns the first n bytes of the decompressed
+// content if header is compressed, so handlers can sniff compressed
+// archives. header itself is returned otherwise.
+func decompressedHeader(header []byte, n int) []byte {
+	if !IsCompressed(header) {
+		return header
+	}
+	dr, err := NewDecompressor(bytes.NewReader(header))
+	if err != nil {
+		return nil
+	}
+	defer dr.Close()
+	buf := make([]byte, n)
+	m, _ := io.ReadFull(dr, buf)
+	return buf[:m]
+}
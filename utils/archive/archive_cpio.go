@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CpioHandler reads cpio archives in the portable ASCII formats (newc,
+// its CRC variant and odc), as used by initramfs images and RPM payloads.
+// Compressed archives such as .cpio.gz are decompressed first.
+type CpioHandler struct{}
+
+const (
+	cpioNewcMagic = "070701"
+	cpioCrcMagic  = "070702"
+	cpioOdcMagic  = "070707"
+	cpioTrailer   = "TRAILER!!!"
+)
+
+func (c *CpioHandler) CanHandle(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	if strings.HasSuffix(lower, ".cpio") {
+		return true
+	}
+	for _, f := range compressedFormats {
+		if strings.HasSuffix(lower, ".cpio"+f.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CpioHandler) Sniff(header []byte) bool {
+	h := decompressedHeader(header, 6)
+	return bytes.HasPrefix(h, []byte(cpioNewcMagic)) ||
+		bytes.HasPrefix(h, []byte(cpioCrcMagic)) ||
+		bytes.HasPrefix(h, []byte(cpioOdcMagic))
+}
+
+func (c *CpioHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	return walkEntries(cpioEntries, src, callback)
+}
+
+// cpioHeader holds the fields of an entry header that are used.
+type cpioHeader struct {
+	mode     int64
+	mtime    int64
+	size     int64
+	nameSize int64
+	align    int64 // newc pads the name and data to 4 bytes, odc doesn't
+}
+
+func cpioEntries(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	dr, err := NewDecompressor(src.reader())
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	cr := &countingReader{r: dr}
+	for {
+		h, err := readCpioHeader(cr)
+		if err != nil {
+			return err
+		}
+
+		nameBuf := make([]byte, h.nameSize)
+		if _, err := io.ReadFull(cr, nameBuf); err != nil {
+			return err
+		}
+		if err := cr.skipToAlign(h.align); err != nil {
+			return err
+		}
+		name := string(bytes.TrimRight(nameBuf, "\x00"))
+		if name == cpioTrailer {
+			return nil
+		}
+
+		info := memberInfo{
+			name:    name,
+			size:    h.size,
+			mode:    cpioFileMode(h.mode),
+			modTime: time.Unix(h.mtime, 0),
+		}
+		data := io.LimitReader(cr, h.size)
+		if err := callback(name, info, data); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+		if err := cr.skipToAlign(h.align); err != nil {
+			return err
+		}
+	}
+}
+
+func readCpioHeader(r io.Reader) (cpioHeader, error) {
+	magic := make([]byte, 6)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return cpioHeader{}, err
+	}
+
+	switch string(magic) {
+	case cpioNewcMagic, cpioCrcMagic:
+		// 13 fields of 8 hex digits: ino, mode, uid, gid, nlink, mtime,
+		// filesize, devmajor, devminor, rdevmajor, rdevminor, namesize, check.
+		buf := make([]byte, 13*8)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return cpioHeader{}, err
+		}
+		field := func(i int) (int64, error) {
+			return strconv.ParseInt(string(buf[i*8:(i+1)*8]), 16, 64)
+		}
+		var h cpioHeader
+		var err error
+		if h.mode, err = field(1); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.mtime, err = field(5); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.size, err = field(6); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.nameSize, err = field(11); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		h.align = 4
+		return h.checked()
+	case cpioOdcMagic:
+		// Octal fields: dev(6) ino(6) mode(6) uid(6) gid(6) nlink(6)
+		// rdev(6) mtime(11) namesize(6) filesize(11).
+		buf := make([]byte, 70)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return cpioHeader{}, err
+		}
+		field := func(off, n int) (int64, error) {
+			return strconv.ParseInt(string(buf[off:off+n]), 8, 64)
+		}
+		var h cpioHeader
+		var err error
+		if h.mode, err = field(12, 6); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.mtime, err = field(42, 11); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.nameSize, err = field(53, 6); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		if h.size, err = field(59, 11); err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+		h.align = 1
+		return h.checked()
+	}
+	return cpioHeader{}, fmt.Errorf("unsupported cpio format %q", magic)
+}
+
+// checked rejects sizes no valid header has, so a damaged one can't make
+// the walk allocate an absurd amount of memory.
+func (h cpioHeader) checked() (cpioHeader, error) {
+	if h.size < 0 || h.nameSize < 0 || h.nameSize > maxNameLen {
+		return cpioHeader{}, fmt.Errorf("invalid cpio header: size %d, name size %d", h.size, h.nameSize)
+	}
+	return h, nil
+}
+
+// cpioFileMode converts the Unix st_mode of an entry.
+func cpioFileMode(mode int64) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	switch mode & 0o170000 {
+	case 0o040000:
+		m |= fs.ModeDir
+	case 0o120000:
+		m |= fs.ModeSymlink
+	case 0o100000:
+	default:
+		m |= fs.ModeIrregular
+	}
+	return m
+}
+
+// countingReader tracks the offset into a stream, for formats that pad
+// entries to a multiple of some size.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) skipToAlign(align int64) error {
+	if pad := (align - c.n%align) % align; pad > 0 {
+		_, err := io.CopyN(io.Discard, c, pad)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+)
+
+// cpioNewc formats a newc entry with the given size fields, padded to 4
+// bytes.
+func cpioNewc(name string, mode int64, size, nameSize string, data string) string {
+	pad := func(s string) string { return s + strings.Repeat("\x00", (4-len(s)%4)%4) }
+	h := fmt.Sprintf("%s%08x%08x%08x%08x%08x%08x%8s%08x%08x%08x%08x%8s%08x",
+		cpioNewcMagic, 0, mode, 0, 0, 1, 0, size, 0, 0, 0, 0, nameSize, 0)
+	return pad(h+name+"\x00") + pad(data)
+}
+
+func cpioFile(name, data string) string {
+	return cpioNewc(name, 0o100644, fmt.Sprintf("%08x", len(data)), fmt.Sprintf("%08x", len(name)+1), data)
+}
+
+func cpioArchive(entries ...string) []byte {
+	return []byte(strings.Join(entries, "") + cpioFile(cpioTrailer, ""))
+}
+
+// cpioOdc formats an odc entry.
+func cpioOdc(name string, size, nameSize string, data string) string {
+	return fmt.Sprintf("%s%06o%06o%06o%06o%06o%06o%06o%011o%6s%11s",
+		cpioOdcMagic, 0, 0, 0o100644, 0, 0, 1, 0, 0, nameSize, size) + name + "\x00" + data
+}
+
+func TestCpioEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "newc",
+			archive: cpioArchive(cpioNewc("dir", 0o040755, "00000000", "00000004", ""), cpioFile("dir/a.txt", "abc"), cpioFile("b", "hello")),
+			want:    map[string]string{"dir/a.txt": "abc", "b": "hello"},
+		},
+		{
+			name:    "odc",
+			archive: []byte(cpioOdc("a.txt", "00000000003", "000006", "abc") + cpioOdc(cpioTrailer, "00000000000", "000013", "")),
+			want:    map[string]string{"a.txt": "abc"},
+		},
+		{name: "not cpio", archive: []byte("hello world"), wantErr: true},
+		{name: "no trailer", archive: []byte(cpioFile("a", "x")), wantErr: true},
+		{name: "negative name size", archive: cpioArchive(cpioNewc("a", 0o100644, "00000000", "-0000001", "")), wantErr: true},
+		{name: "negative size", archive: cpioArchive(cpioNewc("a", 0o100644, "-0000001", "00000002", "")), wantErr: true},
+		{name: "huge name size", archive: cpioArchive(cpioNewc("a", 0o100644, "00000000", "ffffffff", "")), wantErr: true},
+		{name: "bad size", archive: cpioArchive(cpioNewc("a", 0o100644, "zzzzzzzz", "00000002", "")), wantErr: true},
+		{name: "odc negative name size", archive: []byte(cpioOdc("a", "00000000000", "-00001", "")), wantErr: true},
+		{name: "odc negative size", archive: []byte(cpioOdc("a", "-0000000001", "000002", "")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walkMembers(&CpioHandler{}, "t.cpio", tt.archive)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("members = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzCpioEntries(f *testing.F) {
+	f.Add(cpioArchive(cpioFile("a.txt", "abc")))
+	f.Add([]byte(cpioOdc("a.txt", "00000000003", "000006", "abc") + cpioOdc(cpioTrailer, "00000000000", "000013", "")))
+	f.Fuzz(func(t *testing.T, archive []byte) {
+		walkMembers(&CpioHandler{}, "t.cpio", archive)
+	})
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// IsoHandler reads ISO9660 CD and DVD images. Joliet names are used when
+// the image has them, plain ISO9660 names otherwise.
+type IsoHandler struct{}
+
+const (
+	isoSectorSize  = 2048
+	isoMagic       = "CD001"
+	isoMagicOffset = 16*isoSectorSize + 1
+	// isoMaxDepth bounds directory recursion in malformed images.
+	isoMaxDepth = 64
+)
+
+func (i *IsoHandler) CanHandle(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".iso")
+}
+
+func (i *IsoHandler) Sniff(header []byte) bool {
+	return len(header) >= isoMagicOffset+len(isoMagic) &&
+		string(header[isoMagicOffset:isoMagicOffset+len(isoMagic)]) == isoMagic
+}
+
+func (i *IsoHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	return walkEntries(isoEntries, src, callback)
+}
+
+// isoRecord is a directory record: a file or directory entry.
+type isoRecord struct {
+	extent  int64
+	size    int64
+	modTime time.Time
+	isDir   bool
+	name    string
+}
+
+type isoImage struct {
+	src    Source
+	joliet bool
+}
+
+func isoEntries(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	root, joliet, err := readIsoRoot(src)
+	if err != nil {
+		return err
+	}
+	img := &isoImage{src: src, joliet: joliet}
+	return img.walkDir(root, "", 0, callback)
+}
+
+// readIsoRoot finds the root directory record in the volume descriptors,
+// preferring the Joliet supplementary descriptor.
+func readIsoRoot(src Source) (isoRecord, bool, error) {
+	var root isoRecord
+	found, joliet := false, false
+	desc := make([]byte, isoSectorSize)
+	for sector := int64(16); ; sector++ {
+		if _, err := src.R.ReadAt(desc, sector*isoSectorSize); err != nil {
+			return isoRecord{}, false, fmt.Errorf("failed to read iso: %w", err)
+		}
+		if string(desc[1:6]) != isoMagic {
+			return isoRecord{}, false, fmt.Errorf("invalid iso volume descriptor")
+		}
+
+		switch desc[0] {
+		case 1: // primary
+			if !found {
+				root, _ = parseIsoRecord(desc[156:190], false)
+				found = true
+			}
+		case 2: // supplementary, Joliet if it has a UCS-2 escape sequence
+			esc := string(desc[88:91])
+			if esc == "%/@" || esc == "%/C" || esc == "%/E" {
+				root, _ = parseIsoRecord(desc[156:190], true)
+				found, joliet = true, true
+			}
+		case 255: // terminator
+			if !found {
+				return isoRecord{}, false, fmt.Errorf("iso has no primary volume descriptor")
+			}
+			return root, joliet, nil
+		}
+	}
+}
+
+func (img *isoImage) walkDir(dir isoRecord, prefix string, depth int, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	if depth > isoMaxDepth {
+		return fmt.Errorf("iso directories nested too deep")
+	}
+
+	if dir.extent*isoSectorSize+dir.size > img.src.Size {
+		return fmt.Errorf("iso directory extends past the end of the image")
+	}
+	data := make([]byte, dir.size)
+	if _, err := img.src.R.ReadAt(data, dir.extent*isoSectorSize); err != nil {
+		return fmt.Errorf("failed to read iso directory: %w", err)
+	}
+
+	for off := 0; off < len(data); {
+		n := int(data[off])
+		if n == 0 {
+			// Records don't cross sectors; the rest of this one is padding.
+			off = (off/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if off+n > len(data) {
+			break
+		}
+		rec, ok := parseIsoRecord(data[off:off+n], img.joliet)
+		off += n
+		if !ok {
+			continue
+		}
+
+		name := path.Join(prefix, rec.name)
+		mode := fs.FileMode(0o444)
+		if rec.isDir {
+			mode = fs.ModeDir | 0o555
+		}
+		info := memberInfo{name: name, size: rec.size, mode: mode, modTime: rec.modTime}
+
+		var r io.Reader
+		if !rec.isDir {
+			r = io.NewSectionReader(img.src.R, rec.extent*isoSectorSize, rec.size)
+		}
+		if err := callback(name, info, r); err != nil {
+			return err
+		}
+		if rec.isDir {
+			if err := img.walkDir(rec, name, depth+1, callback); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseIsoRecord decodes a directory record. ok is false for the "." and
+// ".." entries and malformed records.
+func parseIsoRecord(b []byte, joliet bool) (isoRecord, bool) {
+	if len(b) < 34 {
+		return isoRecord{}, false
+	}
+	nameLen := int(b[32])
+	if 33+nameLen > len(b) {
+		return isoRecord{}, false
+	}
+	rawName := b[33 : 33+nameLen]
+
+	rec := isoRecord{
+		extent:  int64(binary.LittleEndian.Uint32(b[2:6])),
+		size:    int64(binary.LittleEndian.Uint32(b[10:14])),
+		modTime: isoTime(b[18:25]),
+		isDir:   b[25]&0x2 != 0,
+	}
+	if nameLen == 1 && (rawName[0] == 0 || rawName[0] == 1) {
+		return rec, false
+	}
+
+	if joliet {
+		u := make([]uint16, len(rawName)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(rawName[2*i:])
+		}
+		rec.name = string(utf16.Decode(u))
+	} else {
+		rec.name = string(bytes.ToLower(rawName))
+	}
+	// Strip the file version and the dot of names without an extension.
+	if i := strings.LastIndexByte(rec.name, ';'); i >= 0 {
+		rec.name = rec.name[:i]
+	}
+	if !rec.isDir {
+		rec.name = strings.TrimSuffix(rec.name, ".")
+	}
+	return rec, rec.name != ""
+}
+
+// isoTime decodes the 7-byte recording date of a directory record.
+func isoTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 {
+		return time.Time{}
+	}
+	offset := int(int8(b[6])) * 15 * 60
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]),
+		int(b[3]), int(b[4]), int(b[5]), 0, time.FixedZone("", offset))
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+// isoDirRecord formats a directory record.
+func isoDirRecord(extent, size uint32, dir bool, name string) []byte {
+	n := 33 + len(name)
+	n += n % 2
+	b := make([]byte, n)
+	b[0] = byte(n)
+	binary.LittleEndian.PutUint32(b[2:], extent)
+	binary.BigEndian.PutUint32(b[6:], extent)
+	binary.LittleEndian.PutUint32(b[10:], size)
+	binary.BigEndian.PutUint32(b[14:], size)
+	b[18], b[19], b[20] = 125, 1, 2
+	if dir {
+		b[25] = 0x2
+	}
+	b[32] = byte(len(name))
+	copy(b[33:], name)
+	return b
+}
+
+// isoDir formats the records of a directory, "." and ".." first.
+func isoDir(self, parent uint32, records ...[]byte) []byte {
+	data := append(isoDirRecord(self, isoSectorSize, true, "\x00"), isoDirRecord(parent, isoSectorSize, true, "\x01")...)
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	return data
+}
+
+// testIsoImage builds an image with a primary volume descriptor and the
+// given sectors from sector 18 on, where the root directory of rootSize
+// bytes starts.
+func testIsoImage(rootSize uint32, sectors ...[]byte) []byte {
+	img := make([]byte, (18+len(sectors))*isoSectorSize)
+	pvd := img[16*isoSectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:], isoMagic)
+	copy(pvd[156:], isoDirRecord(18, rootSize, true, "\x00"))
+	term := img[17*isoSectorSize:]
+	term[0] = 255
+	copy(term[1:], isoMagic)
+	for i, s := range sectors {
+		copy(img[(18+i)*isoSectorSize:], s)
+	}
+	return img
+}
+
+func TestIsoEntries(t *testing.T) {
+	valid := testIsoImage(isoSectorSize,
+		isoDir(18, 18,
+			isoDirRecord(20, 3, false, "A.TXT;1"),
+			isoDirRecord(19, isoSectorSize, true, "SUB"),
+			isoDirRecord(21, 5, false, "README.;1"),
+		),
+		isoDir(19, 18, isoDirRecord(21, 5, false, "B.TXT;1")),
+		[]byte("abc"),
+		[]byte("hello"),
+	)
+
+	tests := []struct {
+		name    string
+		image   []byte
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			image: valid,
+			want:  map[string]string{"a.txt": "abc", "sub/b.txt": "hello", "readme": "hello"},
+		},
+		{name: "too short", image: make([]byte, 100), wantErr: true},
+		{name: "no descriptor", image: make([]byte, 20*isoSectorSize), wantErr: true},
+		{name: "root past end", image: testIsoImage(0xffffffff), wantErr: true},
+		{
+			name:    "directory past end",
+			image:   testIsoImage(isoSectorSize, isoDir(18, 18, isoDirRecord(1<<30, isoSectorSize, true, "SUB"))),
+			wantErr: true,
+		},
+		{
+			name:    "directory loop",
+			image:   testIsoImage(isoSectorSize, isoDir(18, 18, isoDirRecord(18, isoSectorSize, true, "LOOP"))),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walkMembers(&IsoHandler{}, "t.iso", tt.image)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("members = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzIsoEntries(f *testing.F) {
+	f.Add(testIsoImage(isoSectorSize, isoDir(18, 18, isoDirRecord(19, 3, false, "A.TXT;1")), []byte("abc")))
+	f.Fuzz(func(t *testing.T, image []byte) {
+		walkMembers(&IsoHandler{}, "t.iso", image)
+	})
+}
@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/nwaples/rardecode"
 )
@@ -16,74 +18,58 @@ func (r *RarHandler) CanHandle(fileName string) bool {
 	return strings.HasSuffix(strings.ToLower(fileName), ".rar")
 }
 
-func (r *RarHandler) Iterate(archPath string, callback func(name string, isDir bool) error) error {
-	file, err := os.Open(archPath)
-	if err != nil {
-		return fmt.Errorf("failed to open rar: %w", err)
-	}
-	defer file.Close()
+// Sniff recognizes both the RAR 1.5 and RAR 5 signatures.
+func (r *RarHandler) Sniff(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("Rar!\x1a\x07"))
+}
 
-	reader, err := rardecode.NewReader(file, "")
+// Walk reads the archive as a single stream, so unlike the other formats
+// it can't skip a member it fails to open: a wrong password or a damaged
+// header ends the walk, and the members after it are not searched.
+func (r *RarHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	reader, err := rardecode.NewReader(src.reader(), src.Password)
 	if err != nil {
-		return fmt.Errorf("failed to read rar: %w", err)
+		return fmt.Errorf("failed to read rar: %w", rarError(err))
 	}
 
 	for {
 		header, err := reader.Next()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			return err
+			return rarError(err)
 		}
 
-		if err := callback(header.Name, header.IsDir); err != nil {
+		if header.IsDir {
+			continue
+		}
+		if err := callback(header.Name, rarFileInfo{header}, reader); err != nil {
 			return err
 		}
 	}
-	return nil
 }
 
-func (r *RarHandler) ReadFile(archPath, targetPath string) (io.ReadCloser, error) {
-	file, err := os.Open(archPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open rar: %w", err)
-	}
-
-	reader, err := rardecode.NewReader(file, "")
-	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to read rar: %w", err)
-	}
-
-	for {
-		header, err := reader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			file.Close()
-			return nil, err
-		}
+// rarBadPassword is the message of the error rardecode returns when the
+// password doesn't match. The error itself is not exported.
+const rarBadPassword = "rardecode: incorrect password"
 
-		if filepath.ToSlash(header.Name) == targetPath {
-			if header.IsDir {
-				file.Close()
-				return nil, fmt.Errorf("path is a directory")
-			}
-			return &rarFileReader{Reader: reader, file: file}, nil
-		}
+// rarError reports rardecode's password errors as ErrPassword.
+func rarError(err error) error {
+	if err.Error() == rarBadPassword {
+		return fmt.Errorf("%w: %v", ErrPassword, err)
 	}
-
-	file.Close()
-	return nil, fmt.Errorf("file %s not found in archive", targetPath)
+	return err
 }
 
-type rarFileReader struct {
-	io.Reader
-	file *os.File
+// rarFileInfo adapts a rardecode header to fs.FileInfo.
+type rarFileInfo struct {
+	h *rardecode.FileHeader
 }
 
-func (r *rarFileReader) Close() error {
-	return r.file.Close()
-}
+func (i rarFileInfo) Name() string       { return path.Base(i.h.Name) }
+func (i rarFileInfo) Size() int64        { return i.h.UnPackedSize }
+func (i rarFileInfo) Mode() fs.FileMode  { return i.h.Mode() }
+func (i rarFileInfo) ModTime() time.Time { return i.h.ModificationTime }
+func (i rarFileInfo) IsDir() bool        { return i.h.IsDir }
+func (i rarFileInfo) Sys() any           { return i.h }
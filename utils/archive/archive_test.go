@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// tarArchive builds a tar holding files, name followed by content.
+func tarArchive(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWalkArchiveNested(t *testing.T) {
+	defer func(n int64) { maxNestedSize = n }(maxNestedSize)
+	maxNestedSize = 4096
+
+	small := tarArchive(t, "b.txt", "inner")
+	large := tarArchive(t, "c.txt", strings.Repeat("x", 8192))
+	outer := tarArchive(t,
+		"a.txt", "outer",
+		"small.tar", string(small),
+		"large.tar", string(large),
+		"broken.tar", "not a tar",
+		"d.txt", "after",
+	)
+
+	got := make(map[string]string)
+	var errs []string
+	src := Source{Name: "outer.tar", R: bytes.NewReader(outer), Size: int64(len(outer))}
+	arch := models.FilePath{Path: "outer.tar"}
+	err := walkArchive(src, arch, 3, func(string) bool { return false }, nil, func(p models.FilePath, info fs.FileInfo, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			var nested *NestedArchiveError
+			if !errors.As(err, &nested) {
+				t.Errorf("%s: error %v is not a NestedArchiveError", p.Format("//"), err)
+			}
+			errs = append(errs, p.Format("//"))
+			return nil
+		}
+		got[p.Format("//")] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkArchive: %v", err)
+	}
+
+	want := map[string]string{
+		"outer.tar//a.txt":            "outer",
+		"outer.tar//small.tar//b.txt": "inner",
+		"outer.tar//d.txt":            "after",
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+	if len(errs) != 2 || errs[0] != "outer.tar//large.tar" || errs[1] != "outer.tar//broken.tar" {
+		t.Errorf("errors on %v, want large.tar and broken.tar", errs)
+	}
+}
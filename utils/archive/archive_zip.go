@@ -2,68 +2,57 @@ package utils
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
-	"path/filepath"
+	"io/fs"
 	"strings"
 )
 
 type ZipHandler struct{}
 
-func (z *ZipHandler) CanHandle(fileName string) bool {
-	return strings.HasSuffix(strings.ToLower(fileName), ".zip")
+// zipExtensions are zip archives under other names: Java, Android,
+// Python wheel and NuGet packages.
+var zipExtensions = []string{
+	".zip", ".jar", ".war", ".ear", ".apk", ".whl", ".nupkg",
 }
 
-func (z *ZipHandler) Iterate(archPath string, callback func(name string, isDir bool) error) error {
-	reader, err := zip.OpenReader(archPath)
-	if err != nil {
-		return fmt.Errorf("failed to open zip: %w", err)
-	}
-	defer reader.Close()
-
-	for _, file := range reader.File {
-		if err := callback(file.Name, file.FileInfo().IsDir()); err != nil {
-			return err
+func (z *ZipHandler) CanHandle(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	for _, ext := range zipExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func (z *ZipHandler) ReadFile(archPath, targetPath string) (io.ReadCloser, error) {
-	reader, err := zip.OpenReader(archPath)
+func (z *ZipHandler) Sniff(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+}
+
+func (z *ZipHandler) Walk(src Source, callback func(name string, info fs.FileInfo, r io.Reader) error) error {
+	reader, err := zip.NewReader(src.R, src.Size)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open zip: %w", err)
+		return fmt.Errorf("failed to open zip: %w", err)
 	}
 
 	for _, file := range reader.File {
-		if filepath.ToSlash(file.Name) == targetPath {
-			if file.FileInfo().IsDir() {
-				reader.Close()
-				return nil, fmt.Errorf("path is a directory")
-			}
-			rc, err := file.Open()
-			if err != nil {
-				reader.Close()
-				return nil, err
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := openZipFile(file, src.Password)
+		if err != nil {
+			if err := callback(file.Name, file.FileInfo(), errReader{err}); err != nil {
+				return err
 			}
-			return &zipFileReader{rc: rc, zr: reader}, nil
+			continue
+		}
+		err = callback(file.Name, file.FileInfo(), rc)
+		rc.Close()
+		if err != nil {
+			return err
 		}
 	}
-
-	reader.Close()
-	return nil, fmt.Errorf("file %s not found in archive", targetPath)
-}
-
-type zipFileReader struct {
-	rc io.ReadCloser
-	zr *zip.ReadCloser
-}
-
-func (z *zipFileReader) Read(p []byte) (n int, err error) {
-	return z.rc.Read(p)
-}
-
-func (z *zipFileReader) Close() error {
-	z.rc.Close()
-	return z.zr.Close()
+	return nil
 }
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	zipFlagEncrypted  = 0x1
+	zipFlagDataDesc   = 0x8
+	zipMethodAES      = 99
+	zipExtraAES       = 0x9901
+	zipCryptoHeader   = 12
+	zipAESVerifierLen = 2
+	zipAESMacLen      = 10
+)
+
+// openZipFile opens a zip member, decrypting it with password if it is
+// encrypted. archive/zip can't read encrypted members, so their raw data
+// is decrypted here (traditional ZipCrypto or WinZip AES) and then
+// decompressed.
+func openZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	if f.Flags&zipFlagEncrypted == 0 {
+		return f.Open()
+	}
+	if password == "" {
+		return nil, ErrPassword
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	method := f.Method
+	checkCRC := true
+	if f.Method == zipMethodAES {
+		ae, err := parseZipAESExtra(f.Extra)
+		if err != nil {
+			return nil, err
+		}
+		r, err = newZipAESReader(raw, int64(f.CompressedSize64), ae.strength, password)
+		if err != nil {
+			return nil, err
+		}
+		method = ae.method
+		// AE-2 stores no CRC, the authentication code covers the data.
+		checkCRC = ae.version == 1
+	} else {
+		// The last header byte is the high byte of the CRC, or of the
+		// modification time when the CRC is stored after the data.
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&zipFlagDataDesc != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+		r, err = newZipCryptoReader(raw, password, check)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("%s: %w", f.Name, zip.ErrAlgorithm)
+	}
+	if checkCRC {
+		rc = &zipChecksumReader{ReadCloser: rc, hash: crc32.NewIEEE(), want: f.CRC32}
+	}
+	return &zipEncryptedReader{ReadCloser: rc}, nil
+}
+
+// zipEncryptedReader reports failures to read an encrypted member as
+// password errors: with a wrong password that passed the header check,
+// the data only fails to decompress or checksum.
+type zipEncryptedReader struct {
+	io.ReadCloser
+}
+
+func (z *zipEncryptedReader) Read(p []byte) (int, error) {
+	n, err := z.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !IsPasswordError(err) {
+		err = fmt.Errorf("%w: %v", ErrPassword, err)
+	}
+	return n, err
+}
+
+// zipChecksumReader verifies the CRC-32 of a member once it is read whole.
+type zipChecksumReader struct {
+	io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func (z *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := z.ReadCloser.Read(p)
+	z.hash.Write(p[:n])
+	if err == io.EOF && z.hash.Sum32() != z.want {
+		return n, fmt.Errorf("%w: checksum error", ErrPassword)
+	}
+	return n, err
+}
+
+// zipCryptoReader decrypts the traditional PKWARE stream cipher.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+func newZipCryptoReader(r io.Reader, password string, check byte) (*zipCryptoReader, error) {
+	z := &zipCryptoReader{r: r, keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+
+	var header [zipCryptoHeader]byte
+	if _, err := io.ReadFull(z, header[:]); err != nil {
+		return nil, err
+	}
+	if header[zipCryptoHeader-1] != check {
+		return nil, ErrPassword
+	}
+	return z, nil
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i := range n {
+		t := z.keys[2] | 2
+		p[i] ^= byte((t * (t ^ 1)) >> 8)
+		z.update(p[i])
+	}
+	return n, err
+}
+
+func (z *zipCryptoReader) update(b byte) {
+	z.keys[0] = crc32Update(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32Update(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+// zipAESExtra is the WinZip AES extra field of an encrypted member.
+type zipAESExtra struct {
+	version  uint16
+	strength byte   // 1, 2 or 3 for AES-128, AES-192 or AES-256
+	method   uint16 // compression method of the decrypted data
+}
+
+func parseZipAESExtra(extra []byte) (zipAESExtra, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == zipExtraAES && size >= 7 {
+			ae := zipAESExtra{
+				version:  binary.LittleEndian.Uint16(extra),
+				strength: extra[4],
+				method:   binary.LittleEndian.Uint16(extra[5:]),
+			}
+			if ae.strength < 1 || ae.strength > 3 {
+				return zipAESExtra{}, fmt.Errorf("unsupported AES strength %d", ae.strength)
+			}
+			return ae, nil
+		}
+		extra = extra[size:]
+	}
+	return zipAESExtra{}, fmt.Errorf("missing AES extra field")
+}
+
+// zipAESReader decrypts WinZip AES data: AES in counter mode with a
+// little-endian counter, authenticated by a truncated HMAC-SHA1.
+type zipAESReader struct {
+	data    io.Reader // the encrypted data
+	raw     io.Reader // the authentication code after it
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+	mac     hash.Hash
+	checked bool
+}
+
+func newZipAESReader(raw io.Reader, compressedSize int64, strength byte, password string) (*zipAESReader, error) {
+	keyLen := 8 * (int(strength) + 1)
+	saltLen := keyLen / 2
+
+	salt := make([]byte, saltLen+zipAESVerifierLen)
+	if _, err := io.ReadFull(raw, salt); err != nil {
+		return nil, err
+	}
+	dataLen := compressedSize - int64(len(salt)) - zipAESMacLen
+	if dataLen < 0 {
+		return nil, fmt.Errorf("AES member too short")
+	}
+
+	key, err := pbkdf2.Key(sha1.New, password, salt[:saltLen], 1000, 2*keyLen+zipAESVerifierLen)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(key[2*keyLen:], salt[saltLen:]) {
+		return nil, ErrPassword
+	}
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &zipAESReader{
+		data:  io.LimitReader(raw, dataLen),
+		raw:   raw,
+		block: block,
+		pos:   aes.BlockSize,
+		mac:   hmac.New(sha1.New, key[keyLen:2*keyLen]),
+	}, nil
+}
+
+func (z *zipAESReader) Read(p []byte) (int, error) {
+	n, err := z.data.Read(p)
+	z.mac.Write(p[:n])
+	for i := range n {
+		if z.pos == aes.BlockSize {
+			for j := range z.counter {
+				z.counter[j]++
+				if z.counter[j] != 0 {
+					break
+				}
+			}
+			z.block.Encrypt(z.stream[:], z.counter[:])
+			z.pos = 0
+		}
+		p[i] ^= z.stream[z.pos]
+		z.pos++
+	}
+
+	if err == io.EOF && !z.checked {
+		z.checked = true
+		var code [zipAESMacLen]byte
+		if _, err := io.ReadFull(z.raw, code[:]); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(z.mac.Sum(nil)[:zipAESMacLen], code[:]) {
+			return n, fmt.Errorf("%w: authentication failed", ErrPassword)
+		}
+	}
+	return n, err
+}
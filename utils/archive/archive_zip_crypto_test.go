@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// zipAESVector holds a.txt, encrypted with WinZip AE-2 AES-256 and the
+// password "secret". It was built by an independent implementation of the
+// WinZip AES spec, not by this package.
+var zipAESVector = mustHex(
+	"504b03043300010063000000210000000000390000001d00000005000b00612e" +
+		"74787401990700020041450300000123456789abcdef0123456789abcdef2a42" +
+		"e4ed381fb4d7cf1e8a96420cb45f090800ec6c758f518f2bf89e745379943a51" +
+		"fdfabd47653403504b010233003300010063000000210000000000390000001d" +
+		"00000005000b000000000000000000000000000000612e747874019907000200" +
+		"4145030000504b050600000000010001003e000000670000000000")
+
+// zipCryptoVector holds b.txt, encrypted with ZipCrypto and the password
+// "secret" by Info-ZIP's zip -P.
+var zipCryptoVector = mustHex(
+	"504b03040a0009000000ec2e525d75e80c4a230000001700000005001c00622e" +
+		"74787455540900034b5fd46a4b5fd46a75780b000104000000000400000000d5" +
+		"a7006453f6b4ca2e57bbb1ff99864bbf873e7197881bb42413ad84ad7f354cb9" +
+		"c3d8504b070875e80c4a2300000017000000504b01021e030a0009000000ec2e" +
+		"525d75e80c4a2300000017000000050018000000000000000000a48100000000" +
+		"622e74787455540500034b5fd46a75780b000104000000000400000000504b05" +
+		"0600000000010001004b000000720000000000")
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestZipEncrypted(t *testing.T) {
+	tests := []struct {
+		name     string
+		archive  []byte
+		password string
+		want     string
+		wantErr  bool
+	}{
+		{"aes", zipAESVector, "secret", "hello aes\nsecond line needle\n", false},
+		{"aes wrong password", zipAESVector, "wrong", "", true},
+		{"aes no password", zipAESVector, "", "", true},
+		{"zipcrypto", zipCryptoVector, "secret", "hello zipcrypto\nneedle\n", false},
+		{"zipcrypto wrong password", zipCryptoVector, "wrong", "", true},
+		{"zipcrypto no password", zipCryptoVector, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := Source{Name: "v.zip", R: bytes.NewReader(tt.archive), Size: int64(len(tt.archive)), Password: tt.password}
+			var got []byte
+			var readErr error
+			err := (&ZipHandler{}).Walk(src, func(name string, info fs.FileInfo, r io.Reader) error {
+				got, readErr = io.ReadAll(r)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if tt.wantErr {
+				if !IsPasswordError(readErr) {
+					t.Fatalf("read error = %v, want a password error", readErr)
+				}
+				return
+			}
+			if readErr != nil {
+				t.Fatalf("read: %v", readErr)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZipAESTampered(t *testing.T) {
+	data := bytes.Clone(zipAESVector)
+	// Flip a bit of the encrypted data, which starts after the 30 byte
+	// local header, the name, the extra field, the salt and the verifier.
+	data[30+len("a.txt")+11+16+2] ^= 1
+	src := Source{Name: "v.zip", R: bytes.NewReader(data), Size: int64(len(data)), Password: "secret"}
+	var readErr error
+	(&ZipHandler{}).Walk(src, func(name string, info fs.FileInfo, r io.Reader) error {
+		_, readErr = io.ReadAll(r)
+		return nil
+	})
+	if !IsPasswordError(readErr) {
+		t.Fatalf("read error = %v, want an authentication failure", readErr)
+	}
+}
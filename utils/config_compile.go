@@ -45,9 +45,11 @@ func compileParts(parts []string) []models.Token {
 func defaultThemeResolved() models.Theme {
 	return models.Theme{
 		Styles: map[string]models.Style{
-			"header":  {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
-			"match":   {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
-			"context": {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: false},
+			"header":      {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
+			"match":       {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
+			"context":     {Fg: color.RGBA{255, 255, 255, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: false},
+			"highlight":   {Fg: color.RGBA{255, 0, 0, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
+			"replacement": {Fg: color.RGBA{0, 255, 0, 255}, Bg: color.RGBA{0, 0, 0, 0}, Bold: true},
 		},
 	}
 }
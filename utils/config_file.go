@@ -35,12 +35,27 @@ bold = true
 [theme.styles.context]
 fg = "#cccccc"
 bold = false
+
+[theme.styles.highlight]
+fg = "#ff5f5f"
+bold = true
+
+[theme.styles.replacement]
+fg = "#5fd75f"
+bold = true
+
+# Passwords for encrypted archives, keyed by a glob matched against the
+# archive's path or name. The longest matching glob wins.
+# [archive.passwords]
+# "backup-*.zip" = "secret"
 `
 
-func LoadConfig() (models.CompiledLayout, models.Theme, error) {
+// LoadConfig reads the layout, theme and archive passwords (keyed by
+// glob) from the config file, falling back to the defaults.
+func LoadConfig() (models.CompiledLayout, models.Theme, map[string]string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return models.CompiledLayout{}, models.Theme{}, err
+		return models.CompiledLayout{}, models.Theme{}, nil, err
 	}
 	path := filepath.Join(home, ".config", "findstr.toml")
 
@@ -50,15 +65,15 @@ func LoadConfig() (models.CompiledLayout, models.Theme, error) {
 	} else if os.IsNotExist(err) {
 		raw = []byte(defaultConfigTOML)
 	} else {
-		return models.CompiledLayout{}, models.Theme{}, err
+		return models.CompiledLayout{}, models.Theme{}, nil, err
 	}
 
 	var cfg models.ConfigJSON
 	if err := toml.Unmarshal(raw, &cfg); err != nil {
-		return models.CompiledLayout{}, models.Theme{}, err
+		return models.CompiledLayout{}, models.Theme{}, nil, err
 	}
 
-	return CompileLayout(fillLayoutDefaults(cfg.Layout)), resolveThemeWithDefaults(cfg.Theme), nil
+	return CompileLayout(fillLayoutDefaults(cfg.Layout)), resolveThemeWithDefaults(cfg.Theme), cfg.Archive.Passwords, nil
 }
 
 func CreateDefaultConfig() (string, error) {
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/HubertasVin/findstr/models"
+	"github.com/HubertasVin/findstr/utils/archive"
+)
+
+// classifyError picks the kind of err, or fallback if nothing about err
+// itself tells, e.g. a read failure is a decode error inside an archive
+// but an I/O error on disk.
+func classifyError(err error, fallback models.ErrorKind) models.ErrorKind {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return models.ErrorPermission
+	case errors.Is(err, ErrLineTooLong):
+		return models.ErrorTooLongLine
+	case utils.IsPasswordError(err):
+		return models.ErrorArchive
+	}
+	var nested *utils.NestedArchiveError
+	if errors.As(err, &nested) {
+		return models.ErrorArchive
+	}
+	return fallback
+}
+
+// sendError sends e unless the search was cancelled.
+func sendError(ctx context.Context, errs chan<- models.SearchError, e models.SearchError) {
+	// select picks at random when both are ready, so a cancelled search
+	// would still queue errors while errs has room.
+	if ctx.Err() != nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case errs <- e:
+	}
+}
+
+// ErrorReport collects the errors of a search in the background, logging
+// each one as it arrives unless quiet.
+type ErrorReport struct {
+	errs   []models.SearchError
+	counts map[models.ErrorKind]int
+	total  int
+	done   chan struct{}
+}
+
+func CollectErrors(errs <-chan models.SearchError, quiet bool, sep string) *ErrorReport {
+	r := &ErrorReport{counts: map[models.ErrorKind]int{}, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		for e := range errs {
+			r.errs = append(r.errs, e)
+			r.counts[e.Kind]++
+			r.total++
+			if quiet {
+				continue
+			}
+			// Encrypted archives without the right password are expected
+			// when searching a mix of archives, so they are only a warning.
+			prefix := "Error:"
+			if utils.IsPasswordError(e.Err) {
+				prefix = "Warning:"
+			}
+			log.Println(prefix, e.Path.Format(sep)+":", e.Err)
+		}
+	}()
+	return r
+}
+
+// Errors waits for the search to end and returns its errors in the order
+// they arrived.
+func (r *ErrorReport) Errors() []models.SearchError {
+	<-r.done
+	return r.errs
+}
+
+// Summary waits for the search to end and describes the errors, e.g.
+// "3 files could not be searched (2 permission, 1 decode)", or returns ""
+// if there were none.
+func (r *ErrorReport) Summary() string {
+	<-r.done
+	if r.total == 0 {
+		return ""
+	}
+
+	var parts []string
+	for _, kind := range models.ErrorKinds {
+		if n := r.counts[kind]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, kind))
+		}
+	}
+	noun := "paths"
+	if r.total == 1 {
+		noun = "path"
+	}
+	return fmt.Sprintf("%d %s could not be searched (%s)", r.total, noun, strings.Join(parts, ", "))
+}
@@ -2,22 +2,19 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/HubertasVin/findstr/models"
 	"github.com/HubertasVin/findstr/utils/archive"
 )
 
-// GetMatchContextLines returns the numeric range around a match.
-func GetMatchContextLines(lineNum int, fileContent []string, contextSize int) []int {
-	left, right := getLinesRange(lineNum, fileContent, contextSize)
-	return makeRange(left, right)
-}
-
 func ReadFileLines(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -25,161 +22,367 @@ func ReadFileLines(path string) ([]string, error) {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Buffer(make([]byte, 0, 64*2048), 2048*2048)
-
+	lr := NewLineReader(file)
 	var lines []string
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	for {
+		line, err := lr.ReadLine()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+}
+
+// LineReader reads newline separated lines. Unlike bufio.Scanner it has
+// no small token size limit, so minified or single-line files are read
+// whole; only lines over MaxLineLen fail, to bound memory.
+type LineReader struct {
+	br  *bufio.Reader
+	buf []byte
+}
+
+// MaxLineLen is the longest line LineReader reads.
+const MaxLineLen = 256 << 20
+
+// ErrLineTooLong is returned for lines longer than MaxLineLen.
+var ErrLineTooLong = errors.New("line too long")
+
+// lineBufSize is the read buffer size used for searched files. Readers
+// that are already a large enough *bufio.Reader are used as is.
+const lineBufSize = 64 * 1024
+
+func NewLineReader(r io.Reader) *LineReader {
+	return &LineReader{br: bufio.NewReaderSize(r, lineBufSize)}
+}
+
+// ReadLine returns the next line without its trailing "\n" or "\r\n".
+// It returns io.EOF once all lines have been read.
+func (lr *LineReader) ReadLine() (string, error) {
+	lr.buf = lr.buf[:0]
+	for {
+		chunk, err := lr.br.ReadSlice('\n')
+		lr.buf = append(lr.buf, chunk...)
+		if len(lr.buf) > MaxLineLen {
+			return "", ErrLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			if len(lr.buf) == 0 {
+				return "", io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	line := lr.buf
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
 	}
-	return lines, nil
+	return string(line), nil
 }
 
-// FilePathWalkDir returns a slice of relative file paths under root. Cancellable.
+// FilePathWalkDir streams the paths of files under root, relative to it. Cancellable.
+// Directories are read concurrently by up to threadCount goroutines, but
+// paths are emitted in the same lexical depth-first order as
+// filepath.WalkDir, so results stay deterministic. Unless noIgnore is
+// set, paths excluded by .gitignore, .ignore and .findstrignore files
+// are skipped. Archives are only emitted with searchArch. Directories that
+// can't be read are sent to errs and skipped. The channel is closed once
+// the walk ends, after the last error is sent.
 func FilePathWalkDir(
 	ctx context.Context,
 	root, excludeDir, excludeFile string,
 	threadCount int,
-	skipGit, searchArch bool,
-) ([]string, error) {
+	skipGit, searchArch, noIgnore bool,
+	errs chan<- models.SearchError,
+) (<-chan models.FilePath, error) {
 	absRoot, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
 	}
+	rootInfo, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, err
+	}
 
-	exNames := map[string]struct{}{}
-	exSubpathsAbs := []string{}
-	for _, ex := range SplitStringToArray(excludeDir, ",") {
-		if ex == "" {
-			continue
+	filter, excludeAll := newPathFilter(excludeDir, excludeFile, skipGit)
+	w := &walker{
+		ctx:        ctx,
+		root:       root,
+		absRoot:    absRoot,
+		filter:     filter,
+		errs:       errs,
+		searchArch: searchArch,
+		noIgnore:   noIgnore,
+		sem:        make(chan struct{}, max(threadCount, 1)),
+		out:        make(chan models.FilePath, 256),
+	}
+	if excludeAll {
+		close(w.out)
+		return w.out, nil
+	}
+
+	go func() {
+		defer close(w.out)
+		if !rootInfo.IsDir() {
+			w.visitFile(absRoot, ".", rootInfo)
+			return
 		}
-		p := filepath.Clean(ex)
-		if p == "." {
-			return []string{}, nil
+		var ignore *dirIgnore
+		if !noIgnore {
+			ignore = rootIgnore(absRoot)
 		}
-		if strings.ContainsRune(p, os.PathSeparator) {
-			exSubpathsAbs = append(exSubpathsAbs, filepath.Join(absRoot, p))
-		} else {
-			exNames[p] = struct{}{}
+		if err := w.walkDir(absRoot, w.readDir(absRoot, ignore)); err != nil && ctx.Err() == nil {
+			w.report(absRoot, err)
 		}
-	}
-	if skipGit {
-		exNames[".git"] = struct{}{}
-	}
+	}()
 
-	excludeFiles := SplitStringToArray(excludeFile, ",")
+	return w.out, nil
+}
 
-	var files []string
-	sep := string(os.PathSeparator)
+var skipSpecial = map[string]struct{}{
+	"proc": {}, "sys": {}, "dev": {}, "run": {}, "lost+found": {},
+}
 
-	skipSpecial := map[string]struct{}{
-		"proc": {}, "sys": {}, "dev": {}, "run": {}, "lost+found": {},
-	}
+type walker struct {
+	ctx        context.Context
+	root       string // as given, to report paths the way matches show them
+	absRoot    string
+	filter     *pathFilter
+	errs       chan<- models.SearchError
+	searchArch bool
+	noIgnore   bool
+	sem        chan struct{} // bounds concurrent directory reads
+	out        chan models.FilePath
+}
 
-	err = filepath.WalkDir(absRoot, func(path string, d fs.DirEntry, walkErr error) error {
+// dirListing is the result of reading one directory in the background.
+type dirListing struct {
+	entries []fs.DirEntry
+	ignore  *dirIgnore // rules in effect inside the directory
+	err     error
+	done    chan struct{}
+}
+
+// readDir starts reading dir in the background and returns immediately.
+func (w *walker) readDir(dir string, ignore *dirIgnore) *dirListing {
+	l := &dirListing{ignore: ignore, done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
 		select {
-		case <-ctx.Done():
-			return fs.SkipAll
-		default:
-		}
-		if walkErr != nil {
-			if os.IsNotExist(walkErr) {
-				return nil
-			}
-			return walkErr
+		case w.sem <- struct{}{}:
+		case <-w.ctx.Done():
+			l.err = w.ctx.Err()
+			return
 		}
+		defer func() { <-w.sem }()
+		l.entries, l.err = os.ReadDir(dir)
+	}()
+	return l
+}
 
-		rel, err := filepath.Rel(absRoot, path)
-		if err != nil {
-			return err
+// walkDir emits the files of an already requested directory listing and
+// recurses into its subdirectories. All subdirectory reads are started
+// before any of them is visited, so they overlap with the emission.
+func (w *walker) walkDir(dir string, l *dirListing) error {
+	select {
+	case <-l.done:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	if l.err != nil {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
 		}
+		if !os.IsNotExist(l.err) {
+			w.report(dir, l.err)
+		}
+		return nil
+	}
 
-		if d.IsDir() {
-			base := filepath.Base(path)
-
-			if _, ok := skipSpecial[rel]; ok {
-				return fs.SkipDir
-			}
-			for s := range skipSpecial {
-				if rel == s || strings.HasPrefix(rel, s+sep) {
-					return fs.SkipDir
-				}
+	subdirs := make([]*dirListing, len(l.entries))
+	for i, e := range l.entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if w.dirExcluded(path) {
+			continue
+		}
+		var ignore *dirIgnore
+		if !w.noIgnore {
+			if l.ignore.ignored(path, true) {
+				continue
 			}
+			ignore = l.ignore.child(path)
+		}
+		subdirs[i] = w.readDir(path, ignore)
+	}
 
-			if _, ok := exNames[base]; ok {
-				return fs.SkipDir
-			}
-			for _, exAbs := range exSubpathsAbs {
-				if path == exAbs || strings.HasPrefix(path, exAbs+sep) {
-					return fs.SkipDir
-				}
+	for i, e := range l.entries {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+		path := filepath.Join(dir, e.Name())
+		if subdirs[i] != nil {
+			if err := w.walkDir(path, subdirs[i]); err != nil {
+				return err
 			}
-			return nil
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+		if !w.noIgnore && l.ignore.ignored(path, false) {
+			continue
 		}
 
-		info, err := d.Info()
+		info, err := e.Info()
 		if err != nil {
-			return nil
+			continue
 		}
-		if !info.Mode().IsRegular() {
-			return nil
+		rel, err := filepath.Rel(w.absRoot, path)
+		if err != nil {
+			return err
 		}
-
-		if fileExcludedByPattern(rel, excludeFiles) {
-			return nil
+		if err := w.visitFile(path, rel, info); err != nil {
+			return err
 		}
-		
-		if (utils.IsCompatibleArchive(rel)) {
-			if (!searchArch) {
-				return nil
-			} else {
-				archFiles, err := utils.GetArchiveFiles(rel, excludeDir, excludeFile, skipGit, searchArch)
-				if (err != nil) {
-					return err
-				}
-				files = append(files, archFiles...)
-			}
+	}
+	return nil
+}
+
+func (w *walker) dirExcluded(path string) bool {
+	rel, err := filepath.Rel(w.absRoot, path)
+	if err != nil {
+		return true
+	}
+	sep := string(os.PathSeparator)
+	for s := range skipSpecial {
+		if rel == s || strings.HasPrefix(rel, s+sep) {
+			return true
 		}
+	}
+
+	return w.filter.dirExcluded(rel)
+}
 
-		files = append(files, rel)
+func (w *walker) visitFile(path, rel string, info fs.FileInfo) error {
+	if !info.Mode().IsRegular() {
 		return nil
-	})
+	}
+	if w.filter.fileExcluded(rel) {
+		return nil
+	}
 
-	if err == fs.SkipAll && ctx.Err() != nil {
-		return files, context.Canceled
+	// Archives are emitted as a single path; their members are searched
+	// by streaming the archive once.
+	if utils.IsCompatibleArchive(rel) && !w.searchArch {
+		return nil
 	}
-	return files, err
+
+	if !w.emit(rel) {
+		return w.ctx.Err()
+	}
+	return nil
 }
 
-func getLinesRange(lineNum int, fileContent []string, contextSize int) (int, int) {
-	left := subtractTo0(lineNum, contextSize)
-	right := addToBound(lineNum, contextSize, len(fileContent)-1)
-	return left, right
+// report sends the error of a directory that couldn't be read.
+func (w *walker) report(dir string, err error) {
+	rel, relErr := filepath.Rel(w.absRoot, dir)
+	if relErr != nil {
+		rel = dir
+	}
+	p := models.FilePath{Path: filepath.Join(w.root, rel)}
+	sendError(w.ctx, w.errs, models.SearchError{Path: p, Kind: classifyError(err, models.ErrorIO), Err: err})
 }
 
-func subtractTo0(x, y int) int {
-	if x-y < 0 {
-		return 0
+func (w *walker) emit(rel string) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case w.out <- models.FilePath{Path: rel}:
+		return true
 	}
-	return x - y
 }
 
-func addToBound(x, y, bound int) int {
-	if x+y > bound {
-		return bound
+// pathFilter holds the --exclude-dir, --exclude-file and --git rules, so
+// they apply the same way to files on disk and to archive members.
+type pathFilter struct {
+	exNames      map[string]struct{}
+	exSubpaths   []string // relative to the root (or the archive)
+	excludeFiles []string
+}
+
+// newPathFilter parses the exclude flags. excludeAll reports that the
+// root itself is excluded.
+func newPathFilter(excludeDir, excludeFile string, skipGit bool) (f *pathFilter, excludeAll bool) {
+	f = &pathFilter{
+		exNames:      map[string]struct{}{},
+		excludeFiles: SplitStringToArray(excludeFile, ","),
 	}
-	return x + y
+	for _, ex := range SplitStringToArray(excludeDir, ",") {
+		if ex == "" {
+			continue
+		}
+		p := filepath.Clean(ex)
+		if p == "." {
+			return f, true
+		}
+		if strings.ContainsRune(p, os.PathSeparator) {
+			f.exSubpaths = append(f.exSubpaths, p)
+		} else {
+			f.exNames[p] = struct{}{}
+		}
+	}
+	if skipGit {
+		f.exNames[".git"] = struct{}{}
+	}
+	return f, false
 }
 
-func makeRange(min, max int) []int {
-	a := make([]int, max-min+1)
-	for i := range a {
-		a[i] = min + i
+// dirExcluded reports whether the directory at rel is excluded.
+func (f *pathFilter) dirExcluded(rel string) bool {
+	if _, ok := f.exNames[filepath.Base(rel)]; ok {
+		return true
+	}
+	sep := string(os.PathSeparator)
+	for _, ex := range f.exSubpaths {
+		if rel == ex || strings.HasPrefix(rel, ex+sep) {
+			return true
+		}
 	}
-	return a
+	return false
+}
+
+// fileExcluded reports whether the file at rel is excluded by pattern.
+func (f *pathFilter) fileExcluded(rel string) bool {
+	return fileExcludedByPattern(rel, f.excludeFiles)
+}
+
+// memberExcluded reports whether an archive member is excluded, either
+// by pattern or because one of its parent directories is. name is
+// slash-separated and relative to the archive root.
+func (f *pathFilter) memberExcluded(name string) bool {
+	rel := filepath.FromSlash(name)
+	for dir := filepath.Dir(rel); dir != "." && dir != string(os.PathSeparator); dir = filepath.Dir(dir) {
+		if f.dirExcluded(dir) {
+			return true
+		}
+	}
+	return f.fileExcluded(rel)
 }
 
 func fileExcludedByPattern(rel string, patterns []string) bool {
@@ -209,23 +412,9 @@ func fileExcludedByPattern(rel string, patterns []string) bool {
 	return false
 }
 
-// IsLikelyBinary does a small read and checks for NUL bytes.
-func IsLikelyBinary(path string) bool {
-	f, err := os.Open(path)
-	if err != nil {
-		return false
-	}
-	defer f.Close()
-
-	buf := make([]byte, 8192)
-	n, _ := io.ReadFull(f, buf)
-	if n < 0 {
-		return false
-	}
-	for i := range n {
-		if buf[i] == 0 {
-			return true
-		}
-	}
-	return false
+// IsLikelyBinary checks the first 8KB of br for NUL bytes. The bytes are
+// peeked, not consumed, so br can be searched afterwards.
+func IsLikelyBinary(br *bufio.Reader) bool {
+	buf, _ := br.Peek(8192)
+	return bytes.IndexByte(buf, 0) >= 0
 }
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single compiled line of a gitignore style file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	base    string // slash-separated absolute directory the rule is relative to
+	negate  bool
+	dirOnly bool
+}
+
+// dirIgnore holds the ignore rules in effect for one directory, in
+// increasing order of precedence: the last matching rule decides.
+type dirIgnore struct {
+	rules  []ignoreRule
+	inRepo bool // .gitignore files only apply inside a git work tree
+}
+
+// ignoreFileNames are read in every directory in this order, so rules
+// from .ignore override .gitignore and .findstrignore overrides both.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".findstrignore"}
+
+// rootIgnore builds the rules for the walk root. When root is inside a
+// git work tree, the global excludes file, .git/info/exclude and the
+// ignore files of the directories between the repository top and root
+// are loaded as well.
+func rootIgnore(absRoot string) *dirIgnore {
+	di := &dirIgnore{}
+	repo := findRepoRoot(absRoot)
+	rel, err := filepath.Rel(repo, absRoot)
+	if repo == "" || err != nil {
+		di.addDir(absRoot)
+		return di
+	}
+
+	di.inRepo = true
+	di.addRepo(repo)
+	dir := repo
+	di.addDir(dir)
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+			dir = filepath.Join(dir, part)
+			di.addDir(dir)
+		}
+	}
+	return di
+}
+
+// child returns the rules for the subdirectory dir.
+func (di *dirIgnore) child(dir string) *dirIgnore {
+	c := &dirIgnore{
+		rules:  di.rules[:len(di.rules):len(di.rules)],
+		inRepo: di.inRepo,
+	}
+	if !c.inRepo {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			c.inRepo = true
+			c.addRepo(dir)
+		}
+	}
+	c.addDir(dir)
+	return c
+}
+
+func (di *dirIgnore) addDir(dir string) {
+	for _, name := range ignoreFileNames {
+		if name == ".gitignore" && !di.inRepo {
+			continue
+		}
+		di.addFile(filepath.Join(dir, name), dir)
+	}
+}
+
+// addRepo loads the rules that apply to a whole repository.
+func (di *dirIgnore) addRepo(repo string) {
+	if global := globalExcludesFile(); global != "" {
+		di.addFile(global, repo)
+	}
+	di.addFile(filepath.Join(repo, ".git", "info", "exclude"), repo)
+}
+
+func (di *dirIgnore) addFile(path, base string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	base = filepath.ToSlash(base)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			di.rules = append(di.rules, rule)
+		}
+	}
+}
+
+// ignored reports whether the absolute path is excluded by the rules.
+func (di *dirIgnore) ignored(path string, isDir bool) bool {
+	if len(di.rules) == 0 {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	ignored := false
+	for _, r := range di.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, ok := strings.CutPrefix(path, strings.TrimSuffix(r.base, "/")+"/")
+		if !ok {
+			continue
+		}
+		if r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreLine compiles one gitignore line relative to base.
+func parseIgnoreLine(line, base string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimUnescapedSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// A slash anywhere but at the end anchors the pattern to base;
+	// otherwise it matches a name at any depth.
+	prefix := "^(?:.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates gitignore wildcards: "*" and "?" never match
+// "/", while "**" as a whole path component matches any number of them.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			atStart := i == 0 || glob[i-1] == '/'
+			rest := glob[i+2:]
+			switch {
+			case atStart && strings.HasPrefix(rest, "/"):
+				b.WriteString("(?:.*/)?")
+				i += 2
+			case atStart && rest == "":
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*")
+				i++
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// trimUnescapedSpace drops trailing spaces unless escaped with a backslash.
+func trimUnescapedSpace(s string) string {
+	for strings.HasSuffix(s, " ") && !strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-1]
+	}
+	if strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-2] + " "
+	}
+	return s
+}
+
+// findRepoRoot returns the closest directory at or above dir that
+// contains a .git entry, or "" when dir is not inside a git work tree.
+func findRepoRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile resolves git's core.excludesFile, falling back to
+// the default $XDG_CONFIG_HOME/git/ignore.
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	if path := readExcludesFileSetting(filepath.Join(home, ".gitconfig")); path != "" {
+		if rest, ok := strings.CutPrefix(path, "~/"); ok && home != "" {
+			return filepath.Join(home, rest)
+		}
+		return path
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// readExcludesFileSetting extracts core.excludesFile from a git config file.
+func readExcludesFileSetting(configPath string) string {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	inCore := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inCore = strings.EqualFold(strings.Trim(line, "[] \t"), "core")
+			continue
+		}
+		if !inCore {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "excludesfile") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
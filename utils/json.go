@@ -1,15 +1,83 @@
 package utils
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"io"
+	"time"
 
+	"github.com/HubertasVin/findstr/mappers"
 	"github.com/HubertasVin/findstr/models"
 )
 
-func BuildJson(fileMatches []models.JsonFileMatch) (string, error) {
-	b, err := json.Marshal(fileMatches)
+// BuildJson renders the --json output: an array of the file matches,
+// followed by an entry for each path that couldn't be searched.
+func BuildJson(fileMatches []models.JsonFileMatch, errs []models.JsonFileError) (string, error) {
+	entries := make([]any, 0, len(fileMatches)+len(errs))
+	for _, fm := range fileMatches {
+		entries = append(entries, fm)
+	}
+	for _, e := range errs {
+		entries = append(entries, e)
+	}
+	b, err := json.Marshal(entries)
 	if err != nil {
 		return "", err
 	}
 	return string(b), nil
 }
+
+// WriteJsonLines streams one JSON event per line to w as file matches
+// and errors arrive, flushing after every file, and finishes with a
+// summary event.
+func WriteJsonLines(
+	ctx context.Context,
+	matches <-chan models.FileMatch,
+	errs <-chan models.SearchError,
+	w io.Writer,
+	start time.Time,
+	sep string,
+) error {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+
+	summary := models.JsonSummary{}
+	for matches != nil || errs != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			summary.Errors++
+			if err := enc.Encode(mappers.MapErrorToJsonEvent(e, sep)); err != nil {
+				return err
+			}
+		case fm, ok := <-matches:
+			if !ok {
+				matches = nil
+				continue
+			}
+			for _, ev := range mappers.MapFileToJsonEvents(fm, sep) {
+				if err := enc.Encode(ev); err != nil {
+					return err
+				}
+				if end, ok := ev.Data.(models.JsonEnd); ok {
+					summary.Files++
+					summary.MatchedLines += end.MatchedLines
+					summary.Matches += end.Matches
+				}
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	summary.ElapsedMs = float64(time.Since(start).Microseconds()) / 1000
+	return enc.Encode(models.JsonEvent{Type: "summary", Data: summary})
+}
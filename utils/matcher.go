@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// Matcher finds occurrences of the search patterns on a single line.
+// Implementations must be safe for concurrent use by the search workers.
+type Matcher interface {
+	// FindAll returns every occurrence on line ordered by start offset, or
+	// nil when the line does not match. Occurrences of the same pattern
+	// never overlap; occurrences of different patterns may.
+	FindAll(line string) []models.Submatch
+}
+
+// boundary restricts where occurrences may start and end, for
+// --word-regexp and --line-regexp.
+type boundary int
+
+const (
+	anyBoundary boundary = iota
+	wordBoundary
+	lineBoundary
+)
+
+// fits reports whether line[start:end] is delimited as b requires: by
+// the ends of the line, or for words also by runes that aren't word
+// characters.
+func (b boundary) fits(line string, start, end int) bool {
+	switch b {
+	case wordBoundary:
+		before, _ := utf8.DecodeLastRuneInString(line[:start])
+		after, _ := utf8.DecodeRuneInString(line[end:])
+		return (start == 0 || !isWordRune(before)) && (end == len(line) || !isWordRune(after))
+	case lineBoundary:
+		return start == 0 && end == len(line)
+	}
+	return true
+}
+
+// firstEmpty returns the first offset of line where an empty occurrence
+// fits, as the literal matcher finds it for an empty pattern.
+func (b boundary) firstEmpty(line string) (int, bool) {
+	for pos := 0; ; {
+		if b.fits(line, pos, pos) {
+			return pos, true
+		}
+		if pos == len(line) {
+			return 0, false
+		}
+		_, size := utf8.DecodeRuneInString(line[pos:])
+		pos += size
+	}
+}
+
+// isWordRune reports whether r is a word character: a Unicode letter,
+// mark, digit or connector punctuation such as '_'.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || unicode.Is(unicode.Pc, r)
+}
+
+// literalMatcher is the fast path for a single case-sensitive literal.
+type literalMatcher struct {
+	pattern string
+	bound   boundary
+}
+
+func (m *literalMatcher) FindAll(line string) []models.Submatch {
+	var subs []models.Submatch
+	for pos := 0; pos <= len(line); {
+		i := strings.Index(line[pos:], m.pattern)
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		end := start + len(m.pattern)
+		if !m.bound.fits(line, start, end) {
+			// An occurrence overlapping this one may still fit.
+			if start == len(line) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(line[start:])
+			pos = start + size
+			continue
+		}
+		subs = append(subs, models.Submatch{Start: start, End: end, Text: line[start:end]})
+		if end == start {
+			break
+		}
+		pos = end
+	}
+	return setRuneOffsets(line, subs)
+}
+
+// multiMatcher finds any number of literals in one pass over the line.
+type multiMatcher struct {
+	ac      *ahoCorasick
+	emptyId []int // patterns that are empty and so match every line
+	bound   boundary
+}
+
+func (m *multiMatcher) FindAll(line string) []models.Submatch {
+	var subs []models.Submatch
+	if len(m.emptyId) > 0 {
+		if pos, ok := m.bound.firstEmpty(line); ok {
+			runes := utf8.RuneCountInString(line[:pos])
+			for _, id := range m.emptyId {
+				subs = append(subs, models.Submatch{Start: pos, End: pos, RuneStart: runes, RuneEnd: runes, PatternId: id})
+			}
+		}
+	}
+	subs = m.ac.scan(line, subs)
+	if len(subs) == 0 {
+		return nil
+	}
+	sortSubmatches(subs)
+
+	// The automaton reports overlapping hits of the same pattern
+	// ("aa" twice in "aaa"); keep the leftmost ones like strings.Index would,
+	// among those that fit the boundary.
+	lastEnd := make(map[int]int)
+	out := subs[:0]
+	for _, sm := range subs {
+		if !m.bound.fits(line, sm.Start, sm.End) {
+			continue
+		}
+		if end, ok := lastEnd[sm.PatternId]; ok && sm.Start < end {
+			continue
+		}
+		lastEnd[sm.PatternId] = sm.End
+		out = append(out, sm)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// regexMatcher runs each expression over the line. Whole lines are
+// matched by anchoring the expressions instead, and whole words by the
+// word variants of them, since RE2's \b only knows ASCII.
+type regexMatcher struct {
+	res   []*regexp.Regexp
+	words []*wordRegexp // with --word-regexp, one per expression
+}
+
+func (m *regexMatcher) FindAll(line string) []models.Submatch {
+	var subs []models.Submatch
+	for id, re := range m.res {
+		var locs [][]int
+		if m.words != nil {
+			locs = m.words[id].findAll(line)
+		} else {
+			locs = re.FindAllStringIndex(line, -1)
+		}
+		for _, loc := range locs {
+			subs = append(subs, models.Submatch{
+				Start:     loc[0],
+				End:       loc[1],
+				Text:      line[loc[0]:loc[1]],
+				PatternId: id,
+			})
+		}
+	}
+	if len(m.res) > 1 {
+		sortSubmatches(subs)
+	}
+	return setRuneOffsets(line, subs)
+}
+
+// nonWord matches a rune that isWordRune rejects.
+const nonWord = `[^\pL\pM\p{Nd}\p{Pc}]`
+
+// wordRegexp finds the occurrences of an expression that are whole words.
+// Dropping the occurrences of the expression itself that aren't would
+// hide those it prefers less: "foo|foobar" finds "foo" in "foobar" and
+// never tries "foobar". The boundaries are made part of the expression
+// instead, so RE2 tries every alternative until one fits. They consume
+// the rune around the occurrence, which is group 1.
+type wordRegexp struct {
+	first *regexp.Regexp // from the start of the line
+	next  *regexp.Regexp // from after a rune, which is included
+}
+
+func newWordRegexp(expr string) (*wordRegexp, error) {
+	first, err := regexp.Compile(`(?:^|` + nonWord + `)(` + expr + `)(?:$|` + nonWord + `)`)
+	if err != nil {
+		return nil, err
+	}
+	next, err := regexp.Compile(nonWord + `(` + expr + `)(?:$|` + nonWord + `)`)
+	if err != nil {
+		return nil, err
+	}
+	return &wordRegexp{first: first, next: next}, nil
+}
+
+// find returns the submatch indices of the first occurrence that starts
+// at pos or later, with group 1 as the occurrence, or nil.
+func (w *wordRegexp) find(line string, pos int) []int {
+	if pos == 0 {
+		return w.first.FindStringSubmatchIndex(line)
+	}
+	// The rune before pos may be the boundary the occurrence starts at.
+	_, size := utf8.DecodeLastRuneInString(line[:pos])
+	from := pos - size
+	loc := w.next.FindStringSubmatchIndex(line[from:])
+	for i := range loc {
+		if loc[i] >= 0 {
+			loc[i] += from
+		}
+	}
+	return loc
+}
+
+// findAll returns the start and end of every occurrence, like
+// FindAllStringIndex.
+func (w *wordRegexp) findAll(line string) [][]int {
+	var locs [][]int
+	for pos := 0; pos <= len(line); {
+		loc := w.find(line, pos)
+		if loc == nil {
+			break
+		}
+		start, end := loc[2], loc[3]
+		locs = append(locs, []int{start, end})
+		if end == start {
+			if end == len(line) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(line[end:])
+			end += size
+		}
+		pos = end
+	}
+	return locs
+}
+
+func sortSubmatches(subs []models.Submatch) {
+	sort.SliceStable(subs, func(a, b int) bool {
+		if subs[a].Start != subs[b].Start {
+			return subs[a].Start < subs[b].Start
+		}
+		return subs[a].PatternId < subs[b].PatternId
+	})
+}
+
+// setRuneOffsets fills RuneStart and RuneEnd for submatches sorted by Start.
+func setRuneOffsets(line string, subs []models.Submatch) []models.Submatch {
+	pos, runes := 0, 0
+	for i := range subs {
+		runes += utf8.RuneCountInString(line[pos:subs[i].Start])
+		pos = subs[i].Start
+		subs[i].RuneStart = runes
+		subs[i].RuneEnd = runes + utf8.RuneCountInString(subs[i].Text)
+	}
+	return subs
+}
+
+// foldRune maps r to the smallest rune of its Unicode simple case folding
+// orbit, so that two runes are equal under folding iff their results are.
+func foldRune(r rune) rune {
+	lowest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < lowest {
+			lowest = f
+		}
+	}
+	return lowest
+}
+
+// NewMatcher builds the matcher described by the program flags.
+// Regular expressions are compiled once here so an invalid pattern
+// is reported before any file is read.
+func NewMatcher(flags models.ProgramFlags) (Matcher, error) {
+	if len(flags.Patterns) == 0 {
+		return nil, fmt.Errorf("no search pattern given")
+	}
+
+	ignoreCase := flags.IgnoreCase
+	if !ignoreCase && flags.SmartCase {
+		ignoreCase = true
+		for _, p := range flags.Patterns {
+			if patternHasUpper(p, flags.Regex) {
+				ignoreCase = false
+				break
+			}
+		}
+	}
+
+	bound := anyBoundary
+	switch {
+	case flags.LineRegexp:
+		bound = lineBoundary
+	case flags.WordRegexp:
+		bound = wordBoundary
+	}
+
+	if !flags.Regex {
+		if len(flags.Patterns) == 1 && !ignoreCase {
+			return &literalMatcher{pattern: flags.Patterns[0], bound: bound}, nil
+		}
+		m := &multiMatcher{ac: newAhoCorasick(flags.Patterns, ignoreCase), bound: bound}
+		for id, p := range flags.Patterns {
+			if p == "" {
+				m.emptyId = append(m.emptyId, id)
+			}
+		}
+		return m, nil
+	}
+
+	m := &regexMatcher{res: make([]*regexp.Regexp, 0, len(flags.Patterns))}
+	for _, p := range flags.Patterns {
+		expr := p
+		if bound == lineBoundary {
+			expr = "^(?:" + expr + ")$"
+		}
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", p, err)
+		}
+		m.res = append(m.res, re)
+		if bound == wordBoundary {
+			w, err := newWordRegexp(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", p, err)
+			}
+			m.words = append(m.words, w)
+		}
+	}
+	return m, nil
+}
+
+// patternHasUpper decides smart-case sensitivity. For regular expressions
+// only literal characters count, so escapes like \W or \S do not switch
+// the search to case-sensitive.
+func patternHasUpper(pattern string, isRegex bool) bool {
+	if !isRegex {
+		return strings.IndexFunc(pattern, unicode.IsUpper) >= 0
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		// Let regexp.Compile report the error.
+		return false
+	}
+	return regexpHasUpper(re)
+}
+
+func regexpHasUpper(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral {
+		for _, r := range re.Rune {
+			if unicode.IsUpper(r) {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if regexpHasUpper(sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,14 +2,14 @@ package utils
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/HubertasVin/findstr/mappers"
 	"github.com/HubertasVin/findstr/models"
 	"github.com/fatih/color"
 )
@@ -26,7 +26,7 @@ func PrintMatches(
 	matches <-chan models.FileMatch,
 	layout models.CompiledLayout,
 	theme models.Theme,
-	contextSize int,
+	flags models.ProgramFlags,
 ) {
 	w := bufio.NewWriterSize(os.Stdout, 1<<20)
 	defer w.Flush()
@@ -34,9 +34,12 @@ func PrintMatches(
 	headerStyleFn := buildStyleFn(theme.Styles["header"])
 	matchStyleFn := buildStyleFn(theme.Styles["match"])
 	contextStyleFn := buildStyleFn(theme.Styles["context"])
+	highlightStyleFn := buildStyleFn(theme.Styles["highlight"])
+	replacementStyleFn := buildStyleFn(theme.Styles["replacement"])
 	const resetClear = "\x1b[0m\x1b[K"
 	const tabWidth = 4
 
+	var rewrites rewriteTally
 	first := true
 	for {
 		select {
@@ -44,8 +47,24 @@ func PrintMatches(
 			return
 		case fm, ok := <-matches:
 			if !ok {
+				if flags.Write {
+					fmt.Fprintln(w)
+					fmt.Fprintln(w, rewrites.summary())
+				}
 				return
 			}
+			rewrites.add(fm.Rewrite)
+
+			// Listing and counting modes print one line per file, meant for
+			// scripts, so nothing but the path is styled.
+			sep := flags.ArchiveSep
+			if summary, ok := fileSummary(fm, flags); ok {
+				fmt.Fprintln(w, headerStyleFn("%s", fm.Path.Format(sep))+summary)
+				if len(matches) == 0 {
+					w.Flush()
+				}
+				continue
+			}
 
 			if !first {
 				fmt.Fprintln(w)
@@ -53,58 +72,166 @@ func PrintMatches(
 			first = false
 
 			fv := fileVars{
-				filepath: fm.File,
-				dir:      filepath.Dir(fm.File),
-				base:     filepath.Base(fm.File),
-				clean:    filepath.Clean(fm.File),
+				filepath: fm.Path.Format(sep),
+				dir:      fm.Path.Dir().Format(sep),
+				base:     fm.Path.Base(),
+				clean:    fm.Path.Clean().Format(sep),
 			}
 
 			leftWidth := 0
-			if layout.AutoWidth && len(fm.ContextLineNums) > 0 {
-				leftWidth = numDigits(fm.ContextLineNums[len(fm.ContextLineNums)-1] + 1)
+			if layout.AutoWidth && len(fm.Lines) > 0 {
+				leftWidth = numDigits(fm.Lines[len(fm.Lines)-1].Num + 1)
 			}
 
 			if len(layout.Header) > 0 {
-				line := renderTokens(layout.Header, fv, 0, "", leftWidth, layout.AlignRight, tabWidth)
-				fmt.Fprint(w, headerStyleFn("%s", line))
+				line := renderTokens(layout.Header, fv, 0, "", nil, leftWidth, layout.AlignRight, tabWidth, headerStyleFn, nil)
+				fmt.Fprint(w, line)
 				fmt.Fprint(w, resetClear)
 				fmt.Fprintln(w)
 			}
 
-			matchSet := make(map[int]struct{}, len(fm.MatchLineNums))
-			for _, ln := range fm.MatchLineNums {
-				matchSet[ln] = struct{}{}
-			}
-
 			prev := -1
-			for _, ln := range fm.ContextLineNums {
-				if prev != -1 && ln-prev > contextSize {
+			for _, l := range fm.Lines {
+				ln := l.Num
+				// Lines of one block are consecutive; a jump means lines
+				// were left out between two blocks.
+				if prev != -1 && ln-prev > 1 {
 					fmt.Fprint(w, headerStyleFn("%s", "..."))
 					fmt.Fprint(w, resetClear)
 					fmt.Fprintln(w)
 				}
 
-				text := fm.FileContent[ln]
-				var tokens []models.Token
-				if _, ok := matchSet[ln]; ok {
-					tokens = layout.Match
-				} else {
-					tokens = layout.Context
+				rep := l.Replacement
+				if flags.MaxColumns > 0 {
+					l = truncateLine(l, flags.MaxColumns)
 				}
 
-				line := renderTokens(tokens, fv, ln+1, text, leftWidth, layout.AlignRight, tabWidth)
-
-				if _, ok := matchSet[ln]; ok {
-					fmt.Fprint(w, matchStyleFn("%s", line))
+				var line string
+				if l.IsMatch() {
+					line = renderTokens(layout.Match, fv, ln+1, l.Text, l.Submatches, leftWidth, layout.AlignRight, tabWidth, matchStyleFn, highlightStyleFn)
 				} else {
-					fmt.Fprint(w, contextStyleFn("%s", line))
+					line = renderTokens(layout.Context, fv, ln+1, l.Text, nil, leftWidth, layout.AlignRight, tabWidth, contextStyleFn, nil)
 				}
+
+				fmt.Fprint(w, line)
 				fmt.Fprint(w, resetClear)
 				fmt.Fprintln(w)
+
+				// With --replace the match is followed by how it will read,
+				// the inserted text highlighted in its own style.
+				if rep != nil {
+					rl := models.Line{Num: ln, Text: rep.Text, Matched: true, Submatches: rep.Spans}
+					if flags.MaxColumns > 0 {
+						rl = truncateLine(rl, flags.MaxColumns)
+					}
+					line = renderTokens(layout.Match, fv, ln+1, rl.Text, rl.Submatches, leftWidth, layout.AlignRight, tabWidth, matchStyleFn, replacementStyleFn)
+					fmt.Fprint(w, line)
+					fmt.Fprint(w, resetClear)
+					fmt.Fprintln(w)
+				}
 				prev = ln
 			}
+
+			// Flush while waiting for the next file so results show up as
+			// they are found rather than when the buffer fills.
+			if len(matches) == 0 {
+				w.Flush()
+			}
+		}
+	}
+}
+
+// fileSummary returns what follows the path of a file in the modes that
+// print one line per file, or false when its lines are printed instead.
+func fileSummary(fm models.FileMatch, flags models.ProgramFlags) (string, bool) {
+	switch {
+	case flags.FilesWithMatches || flags.FilesWithoutMatch:
+		return "", true
+	case flags.Count:
+		return ":" + strconv.Itoa(mappers.CountMatchedLines(fm)), true
+	case flags.CountMatches:
+		return ":" + strconv.Itoa(mappers.CountSubmatches(fm)), true
+	}
+	return "", false
+}
+
+// rewriteTally counts the files changed by --write.
+type rewriteTally struct {
+	files, lines, skipped int
+}
+
+func (t *rewriteTally) add(rw *models.Rewrite) {
+	switch {
+	case rw == nil:
+	case rw.Skipped:
+		t.skipped++
+	case rw.ChangedLines > 0:
+		t.files++
+		t.lines += rw.ChangedLines
+	}
+}
+
+// summary describes the rewrites, e.g. "Changed 5 lines in 2 files
+// (skipped 1 archive member or compressed file)".
+func (t *rewriteTally) summary() string {
+	s := fmt.Sprintf("Changed %d %s in %d %s",
+		t.lines, plural(t.lines, "line", "lines"), t.files, plural(t.files, "file", "files"))
+	if t.skipped > 0 {
+		s += fmt.Sprintf(" (skipped %d %s)", t.skipped,
+			plural(t.skipped, "archive member or compressed file", "archive members or compressed files"))
+	}
+	return s
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// truncateLine shortens lines longer than maxCols bytes to a window
+// around the first occurrence (or the start of a line without one), replacing the
+// cut parts with "[... N more bytes]" markers.
+func truncateLine(l models.Line, maxCols int) models.Line {
+	if len(l.Text) <= maxCols {
+		return l
+	}
+
+	start := 0
+	if len(l.Submatches) > 0 {
+		first := l.Submatches[0]
+		if first.End > maxCols {
+			start = first.Start - max(0, maxCols-(first.End-first.Start))/2
+		}
+	}
+	start = max(0, min(start, len(l.Text)-maxCols))
+	end := start + maxCols
+	for start > 0 && !utf8.RuneStart(l.Text[start]) {
+		start++
+	}
+	for end < len(l.Text) && !utf8.RuneStart(l.Text[end]) {
+		end--
+	}
+
+	var prefix, suffix string
+	if start > 0 {
+		prefix = fmt.Sprintf("[... %d more bytes] ", start)
+	}
+	if end < len(l.Text) {
+		suffix = fmt.Sprintf(" [... %d more bytes]", len(l.Text)-end)
+	}
+
+	out := models.Line{Num: l.Num, Text: prefix + l.Text[start:end] + suffix, Matched: l.Matched}
+	for _, sm := range l.Submatches {
+		if sm.End <= start || sm.Start >= end {
+			continue
 		}
+		sm.Start = len(prefix) + max(sm.Start, start) - start
+		sm.End = len(prefix) + min(sm.End, end) - start
+		out.Submatches = append(out.Submatches, sm)
 	}
+	return out
 }
 
 func buildStyleFn(s models.Style) func(format string, a ...any) string {
@@ -118,16 +245,26 @@ func buildStyleFn(s models.Style) func(format string, a ...any) string {
 	return c.SprintfFunc()
 }
 
+// renderTokens renders a layout line with style applied. Byte spans of
+// text listed in subs are rendered with highlight instead.
 func renderTokens(
 	toks []models.Token,
 	fv fileVars,
 	ln int,
 	text string,
+	subs []models.Submatch,
 	lnWidth int,
 	alignRight bool,
 	tabWidth int,
+	style, highlight func(format string, a ...any) string,
 ) string {
-	var buf bytes.Buffer
+	var out, buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			out.WriteString(style("%s", buf.String()))
+			buf.Reset()
+		}
+	}
 	for _, t := range toks {
 		if !t.IsVar {
 			buf.WriteString(t.Lit)
@@ -145,15 +282,46 @@ func renderTokens(
 		case models.VarLn:
 			buf.WriteString(renderLineNum(ln, lnWidth, alignRight))
 		case models.VarText:
-			buf.WriteString(expandTabs(text, 0, tabWidth))
+			col, pos := 0, 0
+			var seg string
+			for _, sp := range mergeSpans(subs) {
+				seg, col = expandTabs(text[pos:sp[0]], col, tabWidth)
+				buf.WriteString(seg)
+				flush()
+				seg, col = expandTabs(text[sp[0]:sp[1]], col, tabWidth)
+				out.WriteString(highlight("%s", seg))
+				pos = sp[1]
+			}
+			seg, _ = expandTabs(text[pos:], col, tabWidth)
+			buf.WriteString(seg)
+		}
+	}
+	flush()
+	return out.String()
+}
+
+// mergeSpans returns the non-empty byte ranges covered by subs, with
+// overlapping and adjacent ranges joined.
+func mergeSpans(subs []models.Submatch) [][2]int {
+	var spans [][2]int
+	for _, sm := range subs {
+		if sm.End <= sm.Start {
+			continue
 		}
+		if n := len(spans); n > 0 && sm.Start <= spans[n-1][1] {
+			spans[n-1][1] = max(spans[n-1][1], sm.End)
+			continue
+		}
+		spans = append(spans, [2]int{sm.Start, sm.End})
 	}
-	return buf.String()
+	return spans
 }
 
-func expandTabs(s string, startCol, tabWidth int) string {
+// expandTabs replaces tabs with spaces up to the next tab stop. startCol is
+// the column s starts at relative to the text; the column after s is returned.
+func expandTabs(s string, startCol, tabWidth int) (string, int) {
 	if tabWidth <= 0 {
-		return s
+		return s, startCol + len(s)
 	}
 	var b strings.Builder
 	b.Grow(len(s) + 8)
@@ -178,7 +346,7 @@ func expandTabs(s string, startCol, tabWidth int) string {
 			col++
 		}
 	}
-	return b.String()
+	return b.String(), col
 }
 
 func renderLineNum(ln int, lnWidth int, alignRight bool) string {
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// replacer rewrites the occurrences found by a matcher for --replace.
+// In regex mode $1 and ${name} in the replacement refer to the capture
+// groups of the occurrence's pattern; otherwise it is inserted as is.
+type replacer struct {
+	repl  string
+	regex *regexMatcher // nil unless --regex
+}
+
+func newReplacer(matcher Matcher, repl string) *replacer {
+	r := &replacer{repl: repl}
+	if rm, ok := matcher.(*regexMatcher); ok {
+		r.regex = rm
+	}
+	return r
+}
+
+// replace returns line with the occurrences in subs replaced. Where
+// occurrences of different patterns overlap, the leftmost one wins.
+func (r *replacer) replace(line string, subs []models.Submatch) models.Replacement {
+	var b strings.Builder
+	var spans []models.Submatch
+	var locs map[int][][]int
+	pos := 0
+	for _, sm := range subs {
+		// An empty occurrence right after a replaced one was already
+		// covered by it.
+		if sm.Start < pos || sm.Start == sm.End && sm.Start == pos && len(spans) > 0 {
+			continue
+		}
+		b.WriteString(line[pos:sm.Start])
+
+		text := r.repl
+		if r.regex != nil {
+			if locs == nil {
+				locs = make(map[int][][]int)
+			}
+			text = r.expand(line, sm, locs)
+		}
+		start := b.Len()
+		b.WriteString(text)
+		spans = append(spans, models.Submatch{
+			Start:     start,
+			End:       b.Len(),
+			Text:      text,
+			PatternId: sm.PatternId,
+		})
+		pos = sm.End
+	}
+	b.WriteString(line[pos:])
+
+	out := b.String()
+	return models.Replacement{Text: out, Spans: setRuneOffsets(out, spans)}
+}
+
+// expand fills in the capture references of the replacement for sm. The
+// capture groups of a line are looked up once per pattern and cached in
+// locs. Whole words are found one at a time, so they are looked up at sm.
+func (r *replacer) expand(line string, sm models.Submatch, locs map[int][][]int) string {
+	re := r.regex.res[sm.PatternId]
+	if r.regex.words != nil {
+		// Group 1 is the occurrence, and the groups of re follow it.
+		loc := r.regex.words[sm.PatternId].find(line, sm.Start)
+		if loc != nil && loc[2] == sm.Start && loc[3] == sm.End {
+			return string(re.ExpandString(nil, r.repl, line, loc[2:]))
+		}
+		return r.repl
+	}
+	all, ok := locs[sm.PatternId]
+	if !ok {
+		all = re.FindAllStringSubmatchIndex(line, -1)
+		locs[sm.PatternId] = all
+	}
+	for _, loc := range all {
+		if loc[0] == sm.Start && loc[1] == sm.End {
+			return string(re.ExpandString(nil, r.repl, line, loc))
+		}
+	}
+	return r.repl
+}
+
+// rewriteFile writes the replacements of lines, the lines of the file at
+// path that are in the output, back to it. The result is written to a
+// temporary file next to it, which then takes its place, so the file is
+// never left half written. Line endings and the file mode are kept.
+// Nothing is written if no line changes.
+func (r *replacer) rewriteFile(path string, lines []models.Line) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".findstr-*")
+	if err != nil {
+		return 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	changed, err := r.rewrite(f, tmp, lines)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+
+	mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := tmp.Chmod(mode); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	committed = true
+	return changed, nil
+}
+
+// rewrite copies src to dst with the replacements of lines applied and
+// returns how many lines changed. A line is only replaced if it still
+// reads as it did when it was searched.
+func (r *replacer) rewrite(src io.Reader, dst io.Writer, lines []models.Line) (int, error) {
+	reps := make(map[int]models.Line)
+	for _, l := range lines {
+		if l.Replacement != nil && l.Replacement.Text != l.Text {
+			reps[l.Num] = l
+		}
+	}
+
+	br := bufio.NewReaderSize(src, lineBufSize)
+	bw := bufio.NewWriterSize(dst, lineBufSize)
+	changed := 0
+	for num := 0; ; num++ {
+		line, readErr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			// The line ending is split off like LineReader does.
+			text := bytes.TrimSuffix(line, []byte("\n"))
+			text = bytes.TrimSuffix(text, []byte("\r"))
+			eol := line[len(text):]
+			if l, ok := reps[num]; ok && string(text) == l.Text {
+				text = []byte(l.Replacement.Text)
+				changed++
+			}
+			if _, err := bw.Write(text); err != nil {
+				return 0, err
+			}
+			if _, err := bw.Write(eol); err != nil {
+				return 0, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return 0, readErr
+		}
+	}
+	return changed, bw.Flush()
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HubertasVin/findstr/models"
+)
+
+// replacedLines searches text as the search workers do and returns the
+// replacer and the lines with their replacements.
+func replacedLines(t *testing.T, flags models.ProgramFlags, text string) (*replacer, []models.Line) {
+	t.Helper()
+	matcher, err := NewMatcher(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReplacer(matcher, flags.Replace)
+	lines, err := scanMatchLines(strings.NewReader(text), matcher, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range lines {
+		if l.IsMatch() && len(l.Submatches) > 0 {
+			rep := r.replace(l.Text, l.Submatches)
+			lines[i].Replacement = &rep
+		}
+	}
+	return r, lines
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   models.ProgramFlags
+		scanned string // the content when it was searched
+		src     string // the content when it is rewritten
+		want    string
+		changed int
+	}{
+		{
+			name:    "literal",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar"},
+			scanned: "foo\nkeep\nfoo foo\n",
+			want:    "bar\nkeep\nbar bar\n",
+			changed: 2,
+		},
+		{
+			name:    "crlf",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar"},
+			scanned: "foo\r\nkeep\r\nfoo\r\n",
+			want:    "bar\r\nkeep\r\nbar\r\n",
+			changed: 2,
+		},
+		{
+			name:    "no final newline",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar"},
+			scanned: "keep\nfoo",
+			want:    "keep\nbar",
+			changed: 1,
+		},
+		{
+			name:    "crlf without final newline",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar"},
+			scanned: "foo\r\nfoo\r",
+			want:    "bar\r\nbar\r",
+			changed: 2,
+		},
+		{
+			name:    "changed since the search",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar"},
+			scanned: "foo 1\nfoo 2\n",
+			src:     "foo 1\nfoo 2 edited\n",
+			want:    "bar 1\nfoo 2 edited\n",
+			changed: 1,
+		},
+		{
+			name:    "replacement equal to the match",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "foo"},
+			scanned: "foo\n",
+			want:    "foo\n",
+			changed: 0,
+		},
+		{
+			name:    "regex groups",
+			flags:   models.ProgramFlags{Patterns: []string{`get(\w+)\((?P<arg>\w*)\)`}, Regex: true, Replace: "fetch$1(${arg})"},
+			scanned: "getUser(id) getItem()\n",
+			want:    "fetchUser(id) fetchItem()\n",
+			changed: 1,
+		},
+		{
+			name:    "empty regex matches",
+			flags:   models.ProgramFlags{Patterns: []string{"x*"}, Regex: true, Replace: "-"},
+			scanned: "abc\naxxb\n",
+			want:    "-a-b-c-\n-a-b-\n",
+			changed: 2,
+		},
+		{
+			name:    "whole word regex groups",
+			flags:   models.ProgramFlags{Patterns: []string{"(f)oo(bar)?"}, Regex: true, WordRegexp: true, Replace: "<$1|$2>"},
+			scanned: "foobar foo xfoo foo_\n",
+			want:    "<f|bar> <f|> xfoo foo_\n",
+			changed: 1,
+		},
+		{
+			name:    "max count",
+			flags:   models.ProgramFlags{Patterns: []string{"foo"}, Replace: "bar", MaxCount: 1},
+			scanned: "foo\nfoo\n",
+			want:    "bar\nfoo\n",
+			changed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, lines := replacedLines(t, tt.flags, tt.scanned)
+			src := tt.src
+			if src == "" {
+				src = tt.scanned
+			}
+			var out bytes.Buffer
+			changed, err := r.rewrite(strings.NewReader(src), &out, lines)
+			if err != nil {
+				t.Fatalf("rewrite: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("rewrote to %q, want %q", out.String(), tt.want)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed %d lines, want %d", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestRewriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.sh")
+	if err := os.WriteFile(path, []byte("echo foo\r\necho bar\r\n"), 0o751); err != nil {
+		t.Fatal(err)
+	}
+	flags := models.ProgramFlags{Patterns: []string{"foo"}, Replace: "baz"}
+	r, lines := replacedLines(t, flags, "echo foo\r\necho bar\r\n")
+
+	changed, err := r.rewriteFile(path, lines)
+	if err != nil {
+		t.Fatalf("rewriteFile: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("changed %d lines, want 1", changed)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "echo baz\r\necho bar\r\n" {
+		t.Errorf("content = %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o751 {
+		t.Errorf("mode = %v, want 0751", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	// Nothing is written when no line changes.
+	before, _ := os.Stat(path)
+	if changed, err := r.rewriteFile(path, nil); err != nil || changed != 0 {
+		t.Fatalf("rewriteFile with no lines = %d, %v", changed, err)
+	}
+	after, _ := os.Stat(path)
+	if !os.SameFile(before, after) {
+		t.Error("file was replaced although nothing changed")
+	}
+}
@@ -1,19 +1,35 @@
 package utils
 
 import (
+	"bufio"
 	"context"
-	"log"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
-	"sort"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/HubertasVin/chanseq"
 	"github.com/HubertasVin/findstr/models"
 	"github.com/HubertasVin/findstr/utils/archive"
 )
 
-func SearchMatchLines(ctx context.Context, flags models.ProgramFlags) (<-chan models.FileMatch, error) {
+// SearchMatchLines searches the files under flags.Root and streams those
+// with matches, in walk order. Paths that can't be searched are sent on
+// the error channel instead; both channels must be drained, and both are
+// closed once the search ends. The stats are complete by then too.
+func SearchMatchLines(ctx context.Context, flags models.ProgramFlags) (<-chan models.FileMatch, <-chan models.SearchError, *SearchStats, error) {
+	matcher, err := NewMatcher(flags)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// --max-results ends the search early by cancelling this context.
+	ctx, cancel := context.WithCancel(ctx)
+
+	errs := make(chan models.SearchError, 16)
 	paths, err := FilePathWalkDir(ctx,
 		flags.Root,
 		flags.ExcludeDir,
@@ -21,31 +37,55 @@ func SearchMatchLines(ctx context.Context, flags models.ProgramFlags) (<-chan mo
 		flags.ThreadCount,
 		flags.SkipGit,
 		flags.SearchArch,
+		flags.NoIgnore,
+		errs,
 	)
 	if err != nil {
-		return nil, err
+		cancel()
+		return nil, nil, nil, err
 	}
 
-	numWorkers := min(flags.ThreadCount, len(paths))
-	out := runParallel(ctx, paths, flags.Pattern, flags.Root, numWorkers, flags.ContextSize)
-	return out, nil
+	// The walker applies the same rules to files on disk; the root can't
+	// be excluded here since the walk would have yielded nothing.
+	filter, _ := newPathFilter(flags.ExcludeDir, flags.ExcludeFile, flags.SkipGit)
+
+	s := &searcher{
+		ctx:      ctx,
+		flags:    flags,
+		matcher:  matcher,
+		filter:   filter,
+		password: archivePasswords(flags),
+		stats:    newSearchStats(),
+		errs:     errs,
+		cancel:   cancel,
+	}
+	if flags.Replacing {
+		s.replacer = newReplacer(matcher, flags.Replace)
+	}
+	out := runParallel(ctx, paths, s)
+	return out, errs, s.stats, nil
 }
 
+// runParallel searches paths with flags.ThreadCount workers. A job may
+// yield several file matches (one per archive member), so results are
+// reordered per job and flattened afterwards. s.errs is closed and the
+// stats completed once the walk, the workers and the output are done.
+// Once --max-results is reached the rest of the search is cancelled.
+// With --write, files are rewritten as they are output, so only what
+// is left after the limits is written.
 func runParallel(
 	ctx context.Context,
-	paths []string,
-	pattern string,
-	root string,
-	numWorkers int,
-	contextSize int,
+	paths <-chan models.FilePath,
+	s *searcher,
 ) <-chan models.FileMatch {
 	type job struct {
-		idx int
-		rel string
+		idx  int
+		path models.FilePath
 	}
 
+	numWorkers := s.flags.ThreadCount
 	jobs := make(chan job, numWorkers*2)
-	tmp := make(chan chanseq.Seq[models.FileMatch], numWorkers*2)
+	tmp := make(chan chanseq.Seq[[]models.FileMatch], numWorkers*2)
 
 	var wg sync.WaitGroup
 	wg.Add(numWorkers)
@@ -60,78 +100,416 @@ func runParallel(
 					if !ok {
 						return
 					}
-					match := processFile(j.rel, root, contextSize, pattern)
+					res := s.search(j.path)
+
+					var val *[]models.FileMatch
+					if len(res) > 0 {
+						val = &res
+					}
 					select {
 					case <-ctx.Done():
 						return
-					case tmp <- chanseq.Seq[models.FileMatch]{Index: j.idx, Val: match}:
+					case tmp <- chanseq.Seq[[]models.FileMatch]{Index: j.idx, Val: val}:
 					}
 				}
 			}
 		}()
 	}
 
+	// Paths are numbered in discovery order so chanseq can restore that
+	// order no matter which worker finishes first. After cancellation the
+	// paths are still drained, so the walker is done once this returns.
+	walked := make(chan struct{})
 	go func() {
-		for i, rel := range paths {
+		defer close(walked)
+		defer close(jobs)
+		i := 0
+		for p := range paths {
 			select {
 			case <-ctx.Done():
-				break
-			case jobs <- job{idx: i, rel: rel}:
+				continue
+			case jobs <- job{idx: i, path: p}:
 			}
+			i++
 		}
-		close(jobs)
+		s.stats.walkTime = time.Since(s.stats.start)
 	}()
 
+	output := make(chan struct{})
 	go func() {
 		wg.Wait()
+		s.stats.searchTime = time.Since(s.stats.start)
 		close(tmp)
+		<-walked
+		<-output
+		close(s.errs)
+		close(s.stats.done)
 	}()
 
-	out := chanseq.ReorderByIndex(tmp)
+	out := make(chan models.FileMatch, 16)
+	go func() {
+		defer close(output)
+		defer close(out)
+		defer s.cancel()
+		ordered := chanseq.ReorderByIndex(tmp)
+		left := s.flags.MaxResults
+		for res := range ordered {
+			for _, fm := range res {
+				limited := false
+				if s.flags.MaxResults > 0 {
+					fm, left = limitResults(fm, left, s.flags)
+					limited = left == 0
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				fm = s.rewrite(fm)
+				s.stats.addFile(fm)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- fm:
+				}
+				if limited {
+					// Stop the walk and the workers, and let the results
+					// already underway go.
+					s.cancel()
+					for range ordered {
+					}
+					return
+				}
+			}
+		}
+	}()
 	return out
 }
 
-func processFile(
-	relPath, root string,
-	contextSize int,
-	pattern string,
-) *models.FileMatch {
-	full := filepath.Join(root, relPath)
+// limitResults takes a file's results out of the left allowed by
+// --max-results. Results are match lines, or files in the modes that
+// print one line per file. A file with more match lines than left is cut
+// after the last one that fits and its after-context.
+func limitResults(fm models.FileMatch, left int, flags models.ProgramFlags) (models.FileMatch, int) {
+	if flags.FilesWithMatches || flags.FilesWithoutMatch || flags.Count || flags.CountMatches {
+		return fm, left - 1
+	}
 
-	if IsLikelyBinary(full) {
-		return nil
+	for i, l := range fm.Lines {
+		if !l.IsMatch() {
+			continue
+		}
+		if left--; left > 0 {
+			continue
+		}
+		end := i + 1
+		for end < len(fm.Lines) && !fm.Lines[end].IsMatch() && fm.Lines[end].Num-l.Num <= flags.AfterContext {
+			end++
+		}
+		fm.Lines = fm.Lines[:end]
+		return fm, 0
 	}
+	return fm, left
+}
+
+// searcher holds what the workers share to search one walked path.
+type searcher struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	flags    models.ProgramFlags
+	matcher  Matcher
+	filter   *pathFilter
+	password utils.PasswordFunc
+	replacer *replacer // nil without --replace
+	stats    *SearchStats
+	errs     chan models.SearchError
+}
 
-	var lines []string
-	var err error
-	if (utils.IsPathInArchive(full)) {
-		lines, err = utils.ReadArchiveFileLines(full)
-	} else {
-		lines, err = ReadFileLines(full)
+// search searches a file, or with --search-archives every member of an
+// archive. Archives are recognized by their extension or, failing that,
+// by their content.
+func (s *searcher) search(relPath models.FilePath) []models.FileMatch {
+	full := resolvePath(relPath, s.flags.Root)
+	sniff := s.flags.SearchArch
+	if sniff && utils.IsCompatibleArchive(full.Path) {
+		return s.searchArchive(full)
 	}
+
+	rc, info, err := openSearchFile(full.Path)
 	if err != nil {
-		log.Println("Error:", relPath)
+		s.report(full, err, models.ErrorIO)
 		return nil
 	}
+	br := bufio.NewReaderSize(s.stats.countReader(rc), lineBufSize)
+	if sniff {
+		if header, _ := br.Peek(utils.SniffLen); utils.SniffArchive(header) {
+			rc.Close()
+			return s.searchArchive(full)
+		}
+	}
+	defer rc.Close()
+	// Decompressed content can be previewed but not written back.
+	header, _ := br.Peek(8)
+	compressed := s.flags.SearchZip && utils.IsCompressed(header)
 
-	var ctxLines, matchLines []int
-	for i, line := range lines {
-		if strings.Contains(line, pattern) {
-			ctxLines = append(ctxLines, GetMatchContextLines(i, lines, contextSize)...)
-			matchLines = append(matchLines, i)
+	lines, err := s.scan(br)
+	if err == errBinary {
+		return nil
+	}
+	if err != nil {
+		kind := models.ErrorIO
+		if compressed {
+			kind = models.ErrorDecode
 		}
+		s.report(full, err, kind)
+		return nil
 	}
-	if len(ctxLines) == 0 {
+	if !s.listed(lines) {
+		return nil
+	}
+
+	fm := models.FileMatch{
+		Path:    full,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Lines:   lines,
+	}
+	if s.flags.Write && compressed {
+		fm.Rewrite = &models.Rewrite{Skipped: true}
+	}
+	return []models.FileMatch{fm}
+}
+
+// rewrite writes the replacements of a file in the output back to it,
+// unless it was skipped already.
+func (s *searcher) rewrite(fm models.FileMatch) models.FileMatch {
+	if !s.flags.Write || fm.Rewrite != nil {
+		return fm
+	}
+	changed, err := s.replacer.rewriteFile(fm.Path.Path, fm.Lines)
+	if err != nil {
+		s.report(fm.Path, err, models.ErrorIO)
+		return fm
+	}
+	fm.Rewrite = &models.Rewrite{ChangedLines: changed}
+	return fm
+}
+
+// searchArchive searches every member of an archive in one pass over it.
+// Members go through the same exclude rules and binary check as files on
+// disk.
+func (s *searcher) searchArchive(full models.FilePath) []models.FileMatch {
+	var res []models.FileMatch
+	err := utils.WalkArchive(full, s.flags.ArchiveDepth, s.filter.memberExcluded, s.password, func(p models.FilePath, info fs.FileInfo, r io.Reader) error {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		lines, err := s.scan(s.stats.countReader(r))
+		if err == errBinary {
+			return nil
+		}
+		if err != nil {
+			s.report(p, err, models.ErrorDecode)
+			return nil
+		}
+		if s.listed(lines) {
+			fm := models.FileMatch{
+				Path:    p,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+				Lines:   lines,
+			}
+			if s.flags.Write {
+				fm.Rewrite = &models.Rewrite{Skipped: true}
+			}
+			res = append(res, fm)
+		}
 		return nil
+	})
+	// A walk cut short by --max-results or an interrupt isn't an error.
+	if err != nil && s.ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+		s.report(full, err, models.ErrorArchive)
+	}
+	return res
+}
+
+// errBinary is returned by scan for content that looks binary, which is
+// skipped without being reported.
+var errBinary = errors.New("binary content")
+
+// listed reports whether a searched file is part of the output: with
+// --files-without-match if it has no match line, otherwise if it has.
+func (s *searcher) listed(lines []models.Line) bool {
+	if s.flags.FilesWithoutMatch {
+		return len(lines) == 0
+	}
+	return len(lines) > 0
+}
+
+// scan searches the content of a file or archive member. With
+// --search-zip, compressed content is decompressed first, recognized by
+// its signature. Binary content is skipped with errBinary. With
+// --replace, match lines carry their replacement.
+func (s *searcher) scan(r io.Reader) ([]models.Line, error) {
+	s.stats.filesScanned.Add(1)
+	br := bufio.NewReaderSize(r, lineBufSize)
+	if s.flags.SearchZip {
+		if header, _ := br.Peek(8); utils.IsCompressed(header) {
+			dr, err := utils.NewDecompressor(br)
+			if err != nil {
+				return nil, err
+			}
+			defer dr.Close()
+			br = bufio.NewReaderSize(dr, lineBufSize)
+		}
+	}
+	if IsLikelyBinary(br) {
+		return nil, errBinary
+	}
+	lines, err := scanMatchLines(br, s.matcher, s.flags)
+	if err != nil || s.replacer == nil {
+		return lines, err
+	}
+	for i, l := range lines {
+		if l.IsMatch() && len(l.Submatches) > 0 {
+			rep := s.replacer.replace(l.Text, l.Submatches)
+			lines[i].Replacement = &rep
+		}
+	}
+	return lines, nil
+}
+
+// report sends the error of a path that couldn't be searched, with
+// fallback as its kind unless err itself tells.
+func (s *searcher) report(p models.FilePath, err error, fallback models.ErrorKind) {
+	sendError(s.ctx, s.errs, models.SearchError{Path: p, Kind: classifyError(err, fallback), Err: err})
+}
+
+// archivePasswords returns the password to try for each archive: that of
+// the most specific [archive.passwords] glob matching its path or name,
+// else --archive-password.
+func archivePasswords(flags models.ProgramFlags) utils.PasswordFunc {
+	return func(name string) string {
+		best, found := "", false
+		for glob := range flags.ArchivePasswords {
+			// Longer globs are more specific; ties are broken by name so
+			// the choice doesn't depend on map order.
+			if found && (len(glob) < len(best) || len(glob) == len(best) && glob > best) {
+				continue
+			}
+			if ok, _ := filepath.Match(glob, name); !ok {
+				if ok, _ := filepath.Match(glob, filepath.Base(name)); !ok {
+					continue
+				}
+			}
+			best, found = glob, true
+		}
+		if found {
+			return flags.ArchivePasswords[best]
+		}
+		return flags.ArchivePassword
+	}
+}
+
+// resolvePath joins a walk-relative path with root.
+func resolvePath(p models.FilePath, root string) models.FilePath {
+	return models.FilePath{Path: filepath.Join(root, p.Path)}
+}
+
+func openSearchFile(path string) (io.ReadCloser, fs.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
 	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
 
-	ctxLines = RemoveDuplicate(ctxLines)
-	sort.Ints(ctxLines)
+// scanMatchLines streams r line by line and keeps only matched lines,
+// with up to flags.BeforeContext lines before and flags.AfterContext
+// lines after each. With --invert-match the lines without an occurrence
+// are the matched ones. The lines before a match are held in a ring
+// buffer, so memory is bounded by the output, not the input. Modes that
+// list files stop at the first match, unless its lines are to be
+// written back, and counts don't need context.
+// With --max-count the file is only read up to that many match lines.
+func scanMatchLines(r io.Reader, matcher Matcher, flags models.ProgramFlags) ([]models.Line, error) {
+	lr := NewLineReader(r)
 
-	return &models.FileMatch{
-		File:            full,
-		ContextLineNums: ctxLines,
-		MatchLineNums:   matchLines,
-		FileContent:     lines,
+	beforeContext, afterContext := flags.BeforeContext, flags.AfterContext
+	if flags.Count || flags.CountMatches {
+		beforeContext, afterContext = 0, 0
 	}
+
+	var out []models.Line
+	before := newLineRing(beforeContext)
+	after, matched := 0, 0
+	for num := 0; ; num++ {
+		// After --max-count match lines only their after-context is read.
+		if flags.MaxCount > 0 && matched == flags.MaxCount && after == 0 {
+			return out, nil
+		}
+		text, err := lr.ReadLine()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if flags.MaxCount > 0 && matched == flags.MaxCount {
+			out = append(out, models.Line{Num: num, Text: text})
+			after--
+			continue
+		}
+		subs := matcher.FindAll(text)
+		if (len(subs) > 0) != flags.InvertMatch {
+			matched++
+			if (flags.FilesWithMatches || flags.FilesWithoutMatch) && !flags.Write {
+				return []models.Line{{Num: num, Text: text, Matched: true, Submatches: subs}}, nil
+			}
+			out = before.drain(out)
+			out = append(out, models.Line{Num: num, Text: text, Matched: true, Submatches: subs})
+			after = afterContext
+		} else if after > 0 {
+			out = append(out, models.Line{Num: num, Text: text})
+			after--
+		} else {
+			before.push(models.Line{Num: num, Text: text})
+		}
+	}
+}
+
+// lineRing keeps the last few lines seen before a match.
+type lineRing struct {
+	buf   []models.Line
+	start int
+	n     int
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{buf: make([]models.Line, size)}
+}
+
+func (r *lineRing) push(l models.Line) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = l
+		r.n++
+		return
+	}
+	r.buf[r.start] = l
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// drain appends the buffered lines to out in order and empties the ring.
+func (r *lineRing) drain(out []models.Line) []models.Line {
+	for i := range r.n {
+		out = append(out, r.buf[(r.start+i)%len(r.buf)])
+	}
+	r.start, r.n = 0, 0
+	return out
 }
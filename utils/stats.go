@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/HubertasVin/findstr/mappers"
+	"github.com/HubertasVin/findstr/models"
+)
+
+// SearchStats counts the work done by a search for --stats. The workers
+// update the counters as they go. The walk and the search overlap, so
+// each phase is timed from the start of the search until it finished.
+type SearchStats struct {
+	start        time.Time
+	filesScanned atomic.Int64
+	bytesRead    atomic.Int64
+	matchedLines atomic.Int64
+	matches      atomic.Int64
+	walkTime     time.Duration
+	searchTime   time.Duration
+	done         chan struct{}
+}
+
+func newSearchStats() *SearchStats {
+	return &SearchStats{start: time.Now(), done: make(chan struct{})}
+}
+
+// addFile counts the matches of a file in the output.
+func (st *SearchStats) addFile(fm models.FileMatch) {
+	st.matchedLines.Add(int64(mappers.CountMatchedLines(fm)))
+	st.matches.Add(int64(mappers.CountSubmatches(fm)))
+}
+
+// countReader adds what is read from r to the bytes read.
+func (st *SearchStats) countReader(r io.Reader) io.Reader {
+	return &statsReader{r: r, n: &st.bytesRead}
+}
+
+type statsReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *statsReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Format waits for the search to end and describes it, with output as
+// the time the results were done being written.
+func (st *SearchStats) Format(output time.Time) string {
+	<-st.done
+
+	var b strings.Builder
+	row := func(name string, format string, a ...any) {
+		fmt.Fprintf(&b, "%-15s %s\n", name+":", fmt.Sprintf(format, a...))
+	}
+	row("Files scanned", "%d", st.filesScanned.Load())
+	row("Bytes read", "%s", formatBytes(st.bytesRead.Load()))
+	row("Matched lines", "%d", st.matchedLines.Load())
+	row("Matches", "%d", st.matches.Load())
+	row("Walk", "%v", st.walkTime.Round(time.Microsecond))
+	row("Search", "%v", st.searchTime.Round(time.Microsecond))
+	row("Output", "%v", output.Sub(st.start).Round(time.Microsecond))
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}